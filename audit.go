@@ -0,0 +1,281 @@
+package puffin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// AuditRecord is a single entry in an audit log
+type AuditRecord struct {
+	// Seq is the position of the record in the log, starting at 1
+	Seq uint64 `json:"seq"`
+
+	// Time is when the command was started
+	Time time.Time `json:"time"`
+
+	// User and Host identify who ran the command
+	User string `json:"user"`
+	Host string `json:"host"`
+
+	// Path, Args and Dir describe the command, secrets are redacted
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+
+	// ExitCode and Error are the result of the command, see ExitCode
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+
+	// DurationMS is how long the command ran for in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+
+	// StdoutSHA256 and StderrSHA256 are hex encoded hashes of the commands output
+	StdoutSHA256 string `json:"stdout_sha256"`
+	StderrSHA256 string `json:"stderr_sha256"`
+
+	// PrevHash is the hash of the previous record, it's empty for the first record
+	PrevHash string `json:"prev_hash"`
+
+	// Hash is the hex encoded sha256 of the record, it covers every other field
+	Hash string `json:"hash,omitempty"`
+}
+
+// AuditLog writes a tamper-evident record of every command run through its Middleware.
+// Records are written as JSON lines and each record includes the hash of the record
+// before it, so VerifyAudit can detect records that have been edited or removed
+type AuditLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	seq  uint64
+	prev string
+	err  error
+
+	user     string
+	host     string
+	redactor *Redactor
+	now      func() time.Time
+}
+
+// AuditOption configures an AuditLog
+type AuditOption func(*AuditLog)
+
+// WithAuditUser sets the user recorded for every command, the default is the current os user
+func WithAuditUser(name string) AuditOption {
+	return func(l *AuditLog) {
+		l.user = name
+	}
+}
+
+// WithAuditRedactor sets the Redactor used to remove secrets from the recorded args and errors.
+// The default is the DefaultRedactor
+func WithAuditRedactor(r *Redactor) AuditOption {
+	return func(l *AuditLog) {
+		l.redactor = r
+	}
+}
+
+// WithAuditChain continues an existing audit log, seq and hash are the sequence number
+// and hash of the last record in the log
+func WithAuditChain(seq uint64, hash string) AuditOption {
+	return func(l *AuditLog) {
+		l.seq = seq
+		l.prev = hash
+	}
+}
+
+// NewAuditLog creates an AuditLog that writes records to w
+func NewAuditLog(w io.Writer, opts ...AuditOption) *AuditLog {
+	l := &AuditLog{
+		w:        w,
+		redactor: DefaultRedactor(),
+		now:      time.Now,
+	}
+
+	if u, err := user.Current(); err == nil {
+		l.user = u.Username
+	} else {
+		l.user = os.Getenv("USER")
+	}
+	l.host, _ = os.Hostname()
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// OpenAuditLog opens the audit log file at name, creating it if it does not exist.
+// Existing records are verified and new records continue the chain. The caller must
+// close the returned file once the AuditLog is no longer used
+func OpenAuditLog(name string, opts ...AuditOption) (*AuditLog, *os.File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last, err := VerifyAudit(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	opts = append([]AuditOption{WithAuditChain(last.Seq, last.Hash)}, opts...)
+	return NewAuditLog(f, opts...), f, nil
+}
+
+// Err returns the first error that occurred while writing a record
+func (l *AuditLog) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// Middleware returns a Middleware that writes a record for every command once it has completed
+func (l *AuditLog) Middleware() Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		stdout, stderr := sha256.New(), sha256.New()
+
+		return &Hooks{
+			Stdout: stdout,
+			Stderr: stderr,
+			Done: func(res Result) {
+				record := AuditRecord{
+					Time:         res.StartTime.UTC(),
+					User:         l.user,
+					Host:         l.host,
+					Path:         l.redactor.String(cmd.Path()),
+					Args:         l.redactor.Args(cmd.Args()),
+					Dir:          cmd.Dir(),
+					ExitCode:     res.ExitCode,
+					DurationMS:   res.Duration.Milliseconds(),
+					StdoutSHA256: hexHash(stdout),
+					StderrSHA256: hexHash(stderr),
+				}
+				if res.Err != nil {
+					record.Error = l.redactor.Error(res.Err).Error()
+				}
+
+				l.write(record)
+			},
+		}
+	})
+}
+
+// write adds the record to the chain and writes it to the log
+func (l *AuditLog) write(record AuditRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return
+	}
+
+	record.Seq = l.seq + 1
+	record.PrevHash = l.prev
+	record.Hash = ""
+
+	line, sum, err := encodeAuditRecord(record)
+	if err != nil {
+		l.err = err
+		return
+	}
+	if _, err := l.w.Write(line); err != nil {
+		l.err = err
+		return
+	}
+
+	l.seq = record.Seq
+	l.prev = sum
+}
+
+// auditHashPrefix separates the hashed part of an audit record from its hash
+const auditHashPrefix = `,"hash":"`
+
+// encodeAuditRecord encodes the record as a line of JSON. The hash is computed over the
+// exact bytes of the record without the hash field, which is then appended as the last field
+func encodeAuditRecord(record AuditRecord) ([]byte, string, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	line := append(body[:len(body)-1], auditHashPrefix+hash+"\"}\n"...)
+	return line, hash, nil
+}
+
+// AuditError is returned by VerifyAudit when an audit log has been tampered with
+type AuditError struct {
+	// Line is the line number of the first record that failed verification
+	Line int
+
+	// Reason describes why the record failed verification
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("puffin: audit log line %d: %s", e.Line, e.Reason)
+}
+
+// VerifyAudit reads an audit log and checks that no records have been edited, reordered or
+// removed. It returns the last record in the log, which can be compared with a copy kept
+// elsewhere to detect records removed from the end of the log. Tampering is reported as an
+// *AuditError
+func VerifyAudit(r io.Reader) (AuditRecord, error) {
+	var last AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(bytes.TrimSpace(data)) == 0 {
+			return last, &AuditError{Line: line, Reason: "empty record"}
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return last, &AuditError{Line: line, Reason: "invalid record: " + err.Error()}
+		}
+
+		hashStart := len(data) - len(record.Hash) - len(auditHashPrefix) - 2
+		if hashStart < 0 || !bytes.HasSuffix(data, []byte(auditHashPrefix+record.Hash+"\"}")) {
+			return last, &AuditError{Line: line, Reason: "missing hash"}
+		}
+		body := append(append([]byte(nil), data[:hashStart]...), '}')
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != record.Hash {
+			return last, &AuditError{Line: line, Reason: "hash mismatch, the record was modified"}
+		}
+
+		if record.Seq != last.Seq+1 {
+			return last, &AuditError{Line: line, Reason: fmt.Sprintf("sequence %d follows %d, records are missing or reordered", record.Seq, last.Seq)}
+		}
+		if record.PrevHash != last.Hash {
+			return last, &AuditError{Line: line, Reason: "previous hash mismatch, records are missing or reordered"}
+		}
+
+		last = record
+	}
+
+	return last, scanner.Err()
+}
+
+// hexHash returns the hex encoded sum of h
+func hexHash(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,139 @@
+package puffin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// auditLines runs commands through an AuditLog and returns the lines that were written
+func auditLines(t *testing.T) []string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	audit := NewAuditLog(&buf, WithAuditUser("ops"))
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"systemctl": Respond("ok\n", "", 0),
+			"psql":      Respond("", "denied\n", 1),
+		})),
+		audit.Middleware(),
+	)
+
+	exec.Command("systemctl", "restart", "nginx").Run()
+	exec.Command("psql", "--password", "hunter2").Run()
+	exec.Command("systemctl", "status").Run()
+
+	if err := audit.Err(); err != nil {
+		t.Fatalf("AuditLog.Err() = %v", err)
+	}
+
+	return strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func TestAuditLog(t *testing.T) {
+	lines := auditLines(t)
+	if len(lines) != 3 {
+		t.Fatalf("AuditLog wrote %d records, want 3", len(lines))
+	}
+
+	last, err := VerifyAudit(strings.NewReader(strings.Join(lines, "")))
+	if err != nil {
+		t.Fatalf("VerifyAudit() error = %v", err)
+	}
+	if last.Seq != 3 || last.User != "ops" || strings.Join(last.Args, " ") != "systemctl status" {
+		t.Errorf("VerifyAudit() last = %+v", last)
+	}
+
+	if strings.Contains(lines[1], "hunter2") {
+		t.Errorf("AuditLog record contains a secret %s", lines[1])
+	}
+	if !strings.Contains(lines[1], `"exit_code":1`) || !strings.Contains(lines[1], `"error":"exit status 1"`) {
+		t.Errorf("AuditLog record is missing the result %s", lines[1])
+	}
+	stdout := sha256.Sum256([]byte("ok\n"))
+	if !strings.Contains(lines[0], `"stdout_sha256":"`+hex.EncodeToString(stdout[:])+`"`) {
+		t.Errorf("AuditLog record has the wrong stdout hash %s", lines[0])
+	}
+}
+
+func TestVerifyAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(lines []string) []string
+		wantLine int
+	}{
+		{
+			"edited",
+			func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"exit_code":1`, `"exit_code":0`, 1)
+				return lines
+			},
+			2,
+		},
+		{
+			"deleted",
+			func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			2,
+		},
+		{
+			"reordered",
+			func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			2,
+		},
+		{
+			"hash removed",
+			func(lines []string) []string {
+				lines[0] = lines[0][:strings.Index(lines[0], `,"hash"`)] + "}\n"
+				return lines
+			},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.tamper(auditLines(t))
+
+			_, err := VerifyAudit(strings.NewReader(strings.Join(lines, "")))
+			var auditErr *AuditError
+			if !errors.As(err, &auditErr) {
+				t.Fatalf("VerifyAudit() error = %v, want an AuditError", err)
+			}
+			if auditErr.Line != tt.wantLine {
+				t.Errorf("VerifyAudit() line = %d, want %d (%v)", auditErr.Line, tt.wantLine, err)
+			}
+		})
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	exec := NewFuncExec(WithFuncMap(map[string]CmdFunc{"true": Respond("", "", 0)}))
+
+	for i := 0; i < 2; i++ {
+		audit, f, err := OpenAuditLog(name)
+		if err != nil {
+			t.Fatalf("OpenAuditLog() error = %v", err)
+		}
+		Wrap(exec, audit.Middleware()).Command("true").Run()
+		f.Close()
+	}
+
+	audit, f, err := OpenAuditLog(name)
+	if err != nil {
+		t.Fatalf("OpenAuditLog() error = %v", err)
+	}
+	defer f.Close()
+	if audit.seq != 2 {
+		t.Errorf("OpenAuditLog() seq = %d, want 2", audit.seq)
+	}
+}
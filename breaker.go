@@ -0,0 +1,274 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by the errors returned when a CircuitBreaker stops a command
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned by Start when a CircuitBreaker is open for the command
+type CircuitOpenError struct {
+	// Name is the name of the circuit, see WithBreakerKey
+	Name string
+
+	// Until is when the breaker half opens to let a trial command run. It's in the past
+	// if the breaker is half open and the trial command is still running
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", e.Name)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// BreakerState is the state of a circuit
+type BreakerState int
+
+const (
+	// BreakerClosed lets commands run
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen stops commands from running until the cool down has passed
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single trial command run, the circuit closes if it succeeds
+	// and opens again if it fails
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker stops running commands that keep failing. Failures are counted per circuit,
+// by default one for each command name. Once a circuit has failed threshold times in a row it
+// opens and Start fails fast with a *CircuitOpenError. After the cool down one trial command
+// is let through, if it succeeds the circuit closes again
+type CircuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	key       func(cmd Cmd) string
+	failed    func(res Result) bool
+	onChange  func(name string, from, to BreakerState)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of a single circuit
+type circuit struct {
+	state    BreakerState
+	failures int
+	until    time.Time
+	trial    bool
+}
+
+// BreakerOption configures a CircuitBreaker
+type BreakerOption func(*CircuitBreaker)
+
+// WithBreakerThreshold sets the number of failures in a row that open a circuit, the default is 5
+func WithBreakerThreshold(n int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.threshold = n
+	}
+}
+
+// WithBreakerCoolDown sets how long a circuit stays open before a trial command is let through.
+// The default is 30s
+func WithBreakerCoolDown(d time.Duration) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.coolDown = d
+	}
+}
+
+// WithBreakerKey sets the function that returns the circuit of a command.
+// The default is the base name of the command, e.g. aws for /usr/local/bin/aws
+func WithBreakerKey(key func(cmd Cmd) string) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.key = key
+	}
+}
+
+// WithBreakerFailure sets the function that decides if the result of a command is a failure.
+// The default treats every error as a failure, including non-zero exit codes
+func WithBreakerFailure(failed func(res Result) bool) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.failed = failed
+	}
+}
+
+// WithBreakerStateChange sets a function that is called every time a circuit changes state.
+// It's called while the breaker is locked so it must not call the CircuitBreaker
+func WithBreakerStateChange(fn func(name string, from, to BreakerState)) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.onChange = fn
+	}
+}
+
+// NewCircuitBreaker creates a CircuitBreaker with all circuits closed
+func NewCircuitBreaker(opts ...BreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		threshold: 5,
+		coolDown:  30 * time.Second,
+		key:       commandName,
+		failed:    func(res Result) bool { return res.Err != nil },
+		now:       time.Now,
+		circuits:  map[string]*circuit{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Middleware returns a Middleware that makes Start fail with a *CircuitOpenError while the
+// circuit of the command is open. The result of a command is recorded once Wait returns,
+// so every started command must be waited for
+func (b *CircuitBreaker) Middleware() Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		var name string
+		allowed, trial := false, false
+
+		return &Hooks{
+			BeforeStart: func() error {
+				name = b.key(cmd)
+
+				var err error
+				trial, err = b.allow(name)
+				if err != nil {
+					return err
+				}
+				allowed = true
+				return nil
+			},
+			Done: func(res Result) {
+				if allowed {
+					b.record(name, trial, b.failed(res))
+				}
+			},
+		}
+	})
+}
+
+// State returns the state of the circuit with the given name
+func (b *CircuitBreaker) State(name string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && !b.now().Before(c.until) {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// allow checks if a command in the named circuit can run, and whether it's the trial command
+// of a half open circuit
+func (b *CircuitBreaker) allow(name string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		return false, nil
+	}
+
+	switch c.state {
+	case BreakerOpen:
+		if b.now().Before(c.until) {
+			return false, &CircuitOpenError{Name: name, Until: c.until}
+		}
+		b.setState(name, c, BreakerHalfOpen)
+		c.trial = true
+		return true, nil
+	case BreakerHalfOpen:
+		if c.trial {
+			return false, &CircuitOpenError{Name: name, Until: c.until}
+		}
+		c.trial = true
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// record records the result of a command in the named circuit. Only the trial command
+// decides what happens to a half open circuit, commands that were started before the
+// circuit opened are ignored
+func (b *CircuitBreaker) record(name string, trial, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		if !failed {
+			return
+		}
+		c = &circuit{}
+		b.circuits[name] = c
+	}
+
+	switch c.state {
+	case BreakerClosed:
+		if !failed {
+			delete(b.circuits, name)
+			return
+		}
+		c.failures++
+		if c.failures >= b.threshold {
+			b.open(name, c)
+		}
+	case BreakerHalfOpen:
+		if !trial {
+			return
+		}
+		c.trial = false
+		if failed {
+			b.open(name, c)
+			return
+		}
+		b.setState(name, c, BreakerClosed)
+		delete(b.circuits, name)
+	}
+}
+
+// open opens a circuit until the cool down has passed. b.mu must be held
+func (b *CircuitBreaker) open(name string, c *circuit) {
+	c.until = b.now().Add(b.coolDown)
+	b.setState(name, c, BreakerOpen)
+}
+
+// setState changes the state of a circuit and calls the state change func. b.mu must be held
+func (b *CircuitBreaker) setState(name string, c *circuit, state BreakerState) {
+	from := c.state
+	c.state = state
+	if state == BreakerClosed {
+		c.failures = 0
+	}
+
+	if b.onChange != nil && from != state {
+		b.onChange(name, from, state)
+	}
+}
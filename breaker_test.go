@@ -0,0 +1,141 @@
+package puffin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		code      int
+		wantOpen  bool
+		wantState BreakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			"opens after threshold",
+			[]step{
+				{code: 1, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerOpen},
+				{code: 0, wantOpen: true, wantState: BreakerOpen},
+			},
+		},
+		{
+			"success resets failures",
+			[]step{
+				{code: 1, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerClosed},
+				{code: 0, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerClosed},
+			},
+		},
+		{
+			"closes after successful trial",
+			[]step{
+				{code: 1}, {code: 1}, {code: 1, wantState: BreakerOpen},
+				{advance: 30 * time.Second, code: 1, wantOpen: true, wantState: BreakerOpen},
+				{advance: 31 * time.Second, code: 0, wantState: BreakerClosed},
+				{code: 1, wantState: BreakerClosed},
+			},
+		},
+		{
+			"opens again after failed trial",
+			[]step{
+				{code: 1}, {code: 1}, {code: 1, wantState: BreakerOpen},
+				{advance: time.Minute, code: 1, wantState: BreakerOpen},
+				{code: 0, wantOpen: true, wantState: BreakerOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			breaker := NewCircuitBreaker(WithBreakerThreshold(3), WithBreakerCoolDown(time.Minute))
+			breaker.now = func() time.Time { return now }
+
+			var code int
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{
+					"aws": func(fc *FuncCmd) int { return code },
+				})),
+				breaker.Middleware(),
+			)
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				code = s.code
+
+				err := exec.Command("aws", "s3", "ls").Run()
+				var openErr *CircuitOpenError
+				if isOpen := errors.As(err, &openErr); isOpen != s.wantOpen {
+					t.Fatalf("step %d: Run() error = %v, want open %v", i, err, s.wantOpen)
+				}
+				if s.wantOpen && (!errors.Is(err, ErrCircuitOpen) || openErr.Name != "aws") {
+					t.Errorf("step %d: Run() error = %#v, want an open aws circuit", i, openErr)
+				}
+				if got := breaker.State("aws"); got != s.wantState {
+					t.Errorf("step %d: State() = %v, want %v", i, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_halfOpen(t *testing.T) {
+	now := time.Unix(0, 0)
+	var changes []string
+	breaker := NewCircuitBreaker(
+		WithBreakerThreshold(1),
+		WithBreakerStateChange(func(name string, from, to BreakerState) {
+			changes = append(changes, fmt.Sprintf("%s %v->%v", name, from, to))
+		}),
+	)
+	breaker.now = func() time.Time { return now }
+
+	release := make(chan struct{})
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"aws": Sequence(Respond("", "", 1), func(fc *FuncCmd) int {
+				<-release
+				return 0
+			}),
+			"gcloud": Respond("", "", 0),
+		})),
+		breaker.Middleware(),
+	)
+
+	exec.Command("aws").Run()
+	now = now.Add(time.Hour)
+
+	trial := exec.Command("aws")
+	if err := trial.Start(); err != nil {
+		t.Fatalf("trial Start() error = %v", err)
+	}
+	if err := exec.Command("aws").Run(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Run() during trial error = %v, want ErrCircuitOpen", err)
+	}
+	if err := exec.Command("gcloud").Run(); err != nil {
+		t.Errorf("Run() of another command error = %v", err)
+	}
+
+	close(release)
+	if err := trial.Wait(); err != nil {
+		t.Fatalf("trial Wait() error = %v", err)
+	}
+	if err := exec.Command("aws").Run(); err != nil {
+		t.Errorf("Run() after trial error = %v", err)
+	}
+
+	want := []string{"aws closed->open", "aws open->half-open", "aws half-open->closed"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("state changes = %v, want %v", changes, want)
+	}
+}
@@ -0,0 +1,420 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheEntry is the cached result of a command
+type CacheEntry struct {
+	Stdout   []byte    `json:"stdout"`
+	Stderr   []byte    `json:"stderr"`
+	ExitCode int       `json:"exit_code"`
+	Expires  time.Time `json:"expires"`
+}
+
+// expired reports whether the entry has expired at now. Entries with a zero Expires never expire
+func (e CacheEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// CacheStore stores cached results by key. Get must not return expired entries
+type CacheStore interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, entry CacheEntry) error
+}
+
+// MemoryStore is a CacheStore that keeps entries in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]CacheEntry{}}
+}
+
+// Get returns the entry for key if there is one that has not expired
+func (s *MemoryStore) Get(key string) (CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Set stores the entry for key
+func (s *MemoryStore) Set(key string, entry CacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry
+	return nil
+}
+
+// Len returns the number of entries in the store, including entries that have expired
+// but have not been looked up since
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// DiskStore is a CacheStore that keeps each entry in a json file in a directory,
+// so the cache is shared between processes and survives restarts
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates a DiskStore that keeps its entries in dir.
+// The directory is created when the first entry is stored
+func NewDiskStore(dir string) *DiskStore {
+	return &DiskStore{dir: dir}
+}
+
+// Get returns the entry for key if there is one that has not expired.
+// Entries that can not be read are treated as missing
+func (s *DiskStore) Get(key string) (CacheEntry, bool) {
+	data, err := os.ReadFile(s.file(key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return CacheEntry{}, false
+	}
+	if entry.expired(time.Now()) {
+		os.Remove(s.file(key))
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Set stores the entry for key. The file is written to a temporary file first
+// and then renamed so other processes never read a partial entry
+func (s *DiskStore) Set(key string, entry CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file(key))
+}
+
+// file returns the name of the file the entry for key is stored in
+func (s *DiskStore) file(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+// Cache memoises the results of commands that are marked as cacheable. Commands are keyed by
+// a hash of their path, args, env, dir and standard input, and optionally the contents of
+// input files, so a command only hits the cache when it would see exactly the same inputs
+type Cache struct {
+	store     CacheStore
+	ttl       time.Duration
+	failures  bool
+	failTTL   time.Duration
+	cacheable []func(cmd Cmd) bool
+	inputs    func(cmd Cmd) []string
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// CacheOption configures a Cache
+type CacheOption func(*Cache)
+
+// WithCacheTTL sets how long results are cached, the default of 0 caches results forever
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheFailures caches results with a non-zero exit code as well, for ttl. A ttl of 0 uses
+// the TTL of the cache. By default only commands that exit with code 0 are cached, so a
+// transient failure isn't returned again. A cached failure is returned as an error that reports
+// the exit code through ExitCode, but it's not an *exec.ExitError and ProcessState is nil since
+// no process ran
+func WithCacheFailures(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.failures = true
+		c.failTTL = ttl
+	}
+}
+
+// WithCacheable marks the commands that fn returns true for as cacheable
+func WithCacheable(fn func(cmd Cmd) bool) CacheOption {
+	return func(c *Cache) {
+		c.cacheable = append(c.cacheable, fn)
+	}
+}
+
+// WithCacheRules marks the commands that match any of the rules as cacheable,
+// rules are matched the same way as the Allow rules of a Policy
+func WithCacheRules(rules ...PolicyRule) CacheOption {
+	policy := &Policy{Allow: rules}
+	return WithCacheable(func(cmd Cmd) bool {
+		return len(rules) > 0 && policy.Check(cmd) == nil
+	})
+}
+
+// WithCacheInputs sets a function that returns the input files of a command, e.g. go.mod
+// and go.sum for go list. The contents of the files are part of the cache key so the cache
+// is invalidated when they change. Relative paths are relative to the dir of the command
+func WithCacheInputs(fn func(cmd Cmd) []string) CacheOption {
+	return func(c *Cache) {
+		c.inputs = fn
+	}
+}
+
+// NewCache creates a Cache that keeps results in store.
+// No commands are cached unless they are marked with WithCacheable or WithCacheRules
+func NewCache(store CacheStore, opts ...CacheOption) *Cache {
+	c := &Cache{store: store}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Middleware returns a Middleware that returns the cached result of cacheable commands instead
+// of running them. Standard input of cacheable commands is read in full before the command runs
+// since it's part of the cache key, and output is written once the command completes when the
+// result comes from the cache. Only commands that run and exit with code 0 are cached, unless
+// WithCacheFailures is used, errors like a missing executable or a canceled context are never
+// cached, and errors from the store are ignored. When the result comes from the cache no process
+// runs, so Process and ProcessState return nil and the cached exit code is only reported by the
+// error Wait returns, see ExitCode. Commands that are not cacheable run as usual
+func (c *Cache) Middleware() Middleware {
+	return func(base Exec) Exec {
+		return &cacheExec{base: base, cache: c}
+	}
+}
+
+// Stats returns the number of cacheable commands that were served from the cache and
+// the number that had to run
+func (c *Cache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits, c.misses
+}
+
+// isCacheable reports whether any of the cacheable funcs match the command
+func (c *Cache) isCacheable(cmd Cmd) bool {
+	for _, fn := range c.cacheable {
+		if fn(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the cache key of a command, see commandKey
+func (c *Cache) key(cmd Cmd, stdin []byte, combined bool) (string, error) {
+	var inputs []string
+	if c.inputs != nil {
+		inputs = c.inputs(cmd)
+	}
+	return commandKey(cmd, stdin, combined, inputs)
+}
+
+// commandKey returns a hash of the path, args, env, dir and standard input of a command,
+// and the contents of its input files. combined reports whether standard output and
+// standard error go to the same writer, since the output of the command differs when they do
+func commandKey(cmd Cmd, stdin []byte, combined bool, inputs []string) (string, error) {
+	h := sha256.New()
+	field := func(s string) {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+
+	field(cmd.Path())
+	fieldList(field, cmd.Args())
+	fieldList(field, cmd.Environ())
+
+	dir, err := filepath.Abs(cmd.Dir())
+	if err != nil {
+		return "", err
+	}
+	field(dir)
+	field(string(stdin))
+	field(fmt.Sprint(combined))
+
+	fmt.Fprintf(h, "%d:", len(inputs))
+	for _, file := range inputs {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		field(file)
+		if err := digestFile(h, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fieldList writes the number of items in list followed by every item
+func fieldList(field func(string), list []string) {
+	field(fmt.Sprint(len(list)))
+	for _, s := range list {
+		field(s)
+	}
+}
+
+// digestFile writes the sha256 digest of a file to h. A file that doesn't exist has a digest
+// of its own so creating it changes the key
+func digestFile(h hash.Hash, file string) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprint(h, "missing")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return err
+	}
+	h.Write(digest.Sum(nil))
+	return nil
+}
+
+// record counts a cache hit or miss
+func (c *Cache) record(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// cacheExec is an Exec that serves the commands created by the base Exec from a Cache
+type cacheExec struct {
+	base  Exec
+	cache *Cache
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *cacheExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that is served from the cache if it's cacheable
+func (e *cacheExec) Command(name string, arg ...string) Cmd {
+	return newShimCmd(nil, e.base.Command(name, arg...), e.run)
+}
+
+// CommandContext creates a Cmd that is served from the cache if it's cacheable
+func (e *cacheExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return newShimCmd(ctx, e.base.CommandContext(ctx, name, arg...), e.run)
+}
+
+// run writes the cached result of the command, or runs it and caches the result
+func (e *cacheExec) run(s *shimCmd, stdin io.Reader, stdout, stderr io.Writer) error {
+	if !e.cache.isCacheable(s.Cmd) {
+		return s.runClone(s.ctx, e.base, stdin, stdout, stderr)
+	}
+
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	combined := sameWriter(stdout, stderr)
+	key, err := e.cache.key(s.Cmd, input, combined)
+	if err != nil {
+		// the key can't be computed, e.g. an input file can't be read, so the command just runs
+		return s.runClone(s.ctx, e.base, bytes.NewReader(input), stdout, stderr)
+	}
+
+	if entry, ok := e.cache.store.Get(key); ok {
+		e.cache.record(true)
+		stdout.Write(entry.Stdout)
+		if !combined {
+			stderr.Write(entry.Stderr)
+		}
+		if entry.ExitCode != 0 {
+			return &exitError{code: entry.ExitCode}
+		}
+		return nil
+	}
+	e.cache.record(false)
+
+	var outBuf, errBuf bytes.Buffer
+	cmdOut := io.MultiWriter(stdout, &outBuf)
+	cmdErr := io.MultiWriter(stderr, &errBuf)
+	if combined {
+		sw := &syncWriter{w: cmdOut}
+		cmdOut, cmdErr = sw, sw
+	}
+
+	err = s.runClone(s.ctx, e.base, bytes.NewReader(input), cmdOut, cmdErr)
+
+	// only cache commands that ran to completion, start errors and canceled commands may
+	// succeed the next time they run
+	code := ExitCode(err)
+	if code < 0 || (s.ctx != nil && s.ctx.Err() != nil) {
+		return err
+	}
+	if code != 0 && !e.cache.failures {
+		return err
+	}
+
+	ttl := e.cache.ttl
+	if code != 0 && e.cache.failTTL > 0 {
+		ttl = e.cache.failTTL
+	}
+	entry := CacheEntry{Stdout: outBuf.Bytes(), Stderr: errBuf.Bytes(), ExitCode: code}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	e.cache.store.Set(key, entry)
+
+	return err
+}
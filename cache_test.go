@@ -0,0 +1,301 @@
+package puffin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingExec returns an Exec with a cache whose commands count how many times they ran.
+// The commands print their args and stdin, and exit with code 3 if EXIT=3 is set
+func countingExec(cache *Cache) (Exec, *int) {
+	runs := 0
+	fn := func(fc *FuncCmd) int {
+		runs++
+		in := ""
+		if fc.Stdin() != nil {
+			data, _ := readAll(fc.Stdin())
+			in = string(data)
+		}
+		fc.Stdout().Write([]byte(strings.Join(fc.Args(), " ") + in))
+		fc.Stderr().Write([]byte("err"))
+		for _, kv := range fc.Environ() {
+			if kv == "EXIT=3" {
+				return 3
+			}
+		}
+		return 0
+	}
+
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{"go": fn, "git": fn})),
+		cache.Middleware(),
+	)
+	return exec, &runs
+}
+
+// readAll reads from r until a read returns no bytes, a locked reader never returns io.EOF
+func readAll(r interface{ Read([]byte) (int, error) }) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 512)
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if n == 0 || err != nil {
+			return data, nil
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	type call struct {
+		args  []string
+		env   []string
+		stdin string
+	}
+	tests := []struct {
+		name     string
+		calls    []call
+		wantRuns int
+	}{
+		{
+			"same command",
+			[]call{{args: []string{"go", "list"}}, {args: []string{"go", "list"}}},
+			1,
+		},
+		{
+			"different args",
+			[]call{{args: []string{"go", "list"}}, {args: []string{"go", "list", "-json"}}},
+			2,
+		},
+		{
+			"different env",
+			[]call{{args: []string{"go", "list"}, env: []string{"A=1"}}, {args: []string{"go", "list"}, env: []string{"A=2"}}},
+			2,
+		},
+		{
+			"different stdin",
+			[]call{{args: []string{"go", "list"}, stdin: "a"}, {args: []string{"go", "list"}, stdin: "b"}},
+			2,
+		},
+		{
+			"same stdin",
+			[]call{{args: []string{"go", "list"}, stdin: "a"}, {args: []string{"go", "list"}, stdin: "a"}},
+			1,
+		},
+		{
+			"not cacheable",
+			[]call{{args: []string{"git", "push"}}, {args: []string{"git", "push"}}},
+			2,
+		},
+		{
+			"failures are not cached",
+			[]call{{args: []string{"go", "list"}, env: []string{"EXIT=3"}}, {args: []string{"go", "list"}, env: []string{"EXIT=3"}}},
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(NewMemoryStore(), WithCacheRules(PolicyRule{Command: "go"}))
+			exec, runs := countingExec(cache)
+
+			var first string
+			for i, c := range tt.calls {
+				cmd := exec.Command(c.args[0], c.args[1:]...)
+				cmd.SetEnv(append(c.env, "B=0"))
+				if c.stdin != "" {
+					cmd.SetStdin(strings.NewReader(c.stdin))
+				}
+				var stderr strings.Builder
+				cmd.SetStderr(&stderr)
+				out, err := cmd.Output()
+
+				wantCode := 0
+				if len(c.env) > 0 && c.env[0] == "EXIT=3" {
+					wantCode = 3
+				}
+				if ExitCode(err) != wantCode {
+					t.Errorf("call %d: ExitCode() = %d, want %d", i, ExitCode(err), wantCode)
+				}
+				if stderr.String() != "err" {
+					t.Errorf("call %d: stderr = %q, want %q", i, stderr.String(), "err")
+				}
+				if i == 0 {
+					first = string(out)
+				} else if *runs == 1 && string(out) != first {
+					t.Errorf("call %d: cached output = %q, want %q", i, out, first)
+				}
+			}
+
+			if *runs != tt.wantRuns {
+				t.Errorf("command ran %d times, want %d", *runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestCache_failures(t *testing.T) {
+	cache := NewCache(NewMemoryStore(), WithCacheRules(PolicyRule{Command: "go"}), WithCacheFailures(50*time.Millisecond))
+	exec, runs := countingExec(cache)
+
+	run := func() {
+		cmd := exec.Command("go", "list")
+		cmd.SetEnv([]string{"EXIT=3"})
+		if err := cmd.Run(); ExitCode(err) != 3 {
+			t.Errorf("Run() error = %v, want exit code 3", err)
+		}
+	}
+
+	run()
+	run()
+	if *runs != 1 {
+		t.Errorf("command ran %d times, want the failure to be cached", *runs)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	run()
+	if *runs != 2 {
+		t.Errorf("command ran %d times, want the cached failure to expire", *runs)
+	}
+}
+
+func TestCache_processState(t *testing.T) {
+	cache := NewCache(NewMemoryStore(), WithCacheRules(PolicyRule{Command: "go"}))
+	exec, _ := countingExec(cache)
+
+	tests := []struct {
+		name      string
+		wantState bool
+	}{
+		{"miss", true},
+		// no process runs for a hit
+		{"hit", false},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("go", "list")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("%s: Run() error = %v", tt.name, err)
+		}
+		state := cmd.ProcessState()
+		if (state != nil) != tt.wantState {
+			t.Errorf("%s: ProcessState() = %v, want a state %v", tt.name, state, tt.wantState)
+		}
+		if state != nil && !state.Success() {
+			t.Errorf("%s: ProcessState() = %v, want a successful exit", tt.name, state)
+		}
+	}
+	if hits, _ := cache.Stats(); hits != 1 {
+		t.Errorf("Stats() hits = %d, want 1", hits)
+	}
+}
+
+func TestCache_combinedOutput(t *testing.T) {
+	cache := NewCache(NewMemoryStore(), WithCacheable(func(cmd Cmd) bool { return true }))
+	exec, runs := countingExec(cache)
+
+	for i := 0; i < 2; i++ {
+		out, err := exec.Command("go", "version").CombinedOutput()
+		if err != nil {
+			t.Fatalf("CombinedOutput() error = %v", err)
+		}
+		if string(out) != "go versionerr" {
+			t.Errorf("CombinedOutput() = %q, want %q", out, "go versionerr")
+		}
+	}
+	if *runs != 1 {
+		t.Errorf("command ran %d times, want 1", *runs)
+	}
+
+	// separate stdout and stderr are cached separately from the combined output
+	out, _ := exec.Command("go", "version").Output()
+	if string(out) != "go version" {
+		t.Errorf("Output() = %q, want %q", out, "go version")
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Stats() = %d, %d, want 1, 2", hits, misses)
+	}
+}
+
+func TestCache_inputs(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(
+		NewMemoryStore(),
+		WithCacheRules(PolicyRule{Command: "go"}),
+		WithCacheInputs(func(cmd Cmd) []string { return []string{"go.mod"} }),
+	)
+	exec, runs := countingExec(cache)
+
+	run := func() {
+		cmd := exec.Command("go", "list")
+		cmd.SetDir(dir)
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+
+	steps := []struct {
+		name     string
+		change   func()
+		wantRuns int
+	}{
+		{"missing input", func() {}, 1},
+		{"unchanged", func() {}, 1},
+		{"created", func() { os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module a"), 0o644) }, 2},
+		{"unchanged", func() {}, 2},
+		{"changed", func() { os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module b"), 0o644) }, 3},
+	}
+	for _, step := range steps {
+		step.change()
+		run()
+		if *runs != step.wantRuns {
+			t.Errorf("%s: command ran %d times, want %d", step.name, *runs, step.wantRuns)
+		}
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	stores := map[string]CacheStore{
+		"memory": NewMemoryStore(),
+		"disk":   NewDiskStore(filepath.Join(t.TempDir(), "cache")),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := store.Get("missing"); ok {
+				t.Error("Get(missing) found an entry")
+			}
+
+			want := CacheEntry{Stdout: []byte("out"), Stderr: []byte("err"), ExitCode: 2}
+			if err := store.Set("key", want); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			got, ok := store.Get("key")
+			if !ok {
+				t.Fatal("Get(key) found no entry")
+			}
+			if string(got.Stdout) != "out" || string(got.Stderr) != "err" || got.ExitCode != 2 {
+				t.Errorf("Get(key) = %+v, want %+v", got, want)
+			}
+
+			store.Set("expired", CacheEntry{Stdout: []byte("old"), Expires: time.Now().Add(-time.Second)})
+			if _, ok := store.Get("expired"); ok {
+				t.Error("Get(expired) found an entry")
+			}
+		})
+	}
+}
+
+func TestCache_ttl(t *testing.T) {
+	cache := NewCache(NewMemoryStore(), WithCacheRules(PolicyRule{Command: "go"}), WithCacheTTL(20*time.Millisecond))
+	exec, runs := countingExec(cache)
+
+	exec.Command("go", "env").Run()
+	exec.Command("go", "env").Run()
+	time.Sleep(30 * time.Millisecond)
+	exec.Command("go", "env").Run()
+
+	if *runs != 2 {
+		t.Errorf("command ran %d times, want 2", *runs)
+	}
+}
@@ -0,0 +1,381 @@
+package puffin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Fault is a kind of failure a Chaos injects into commands
+type Fault int
+
+const (
+	// FaultStart makes Start fail with a *FaultError
+	FaultStart Fault = iota + 1
+
+	// FaultExit makes the command exit with ChaosRule.ExitCode without running it
+	FaultExit
+
+	// FaultTruncate cuts the standard output of the command short
+	FaultTruncate
+
+	// FaultCorrupt changes random bytes of the standard output of the command
+	FaultCorrupt
+
+	// FaultDelay holds back the output of the command and the result of Wait
+	// for ChaosRule.Delay
+	FaultDelay
+
+	// FaultHang makes the command hang until its context is done, without running it.
+	// Commands created without a context hang forever
+	FaultHang
+
+	// FaultKill kills the command after it has run for ChaosRule.Delay,
+	// Wait returns a *FaultError if the command was still running
+	FaultKill
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultStart:
+		return "start"
+	case FaultExit:
+		return "exit"
+	case FaultTruncate:
+		return "truncate"
+	case FaultCorrupt:
+		return "corrupt"
+	case FaultDelay:
+		return "delay"
+	case FaultHang:
+		return "hang"
+	case FaultKill:
+		return "kill"
+	default:
+		return fmt.Sprintf("Fault(%d)", int(f))
+	}
+}
+
+// FaultError is the error returned by a command that failed because of an injected fault
+type FaultError struct {
+	Fault Fault
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("chaos: injected %s fault", e.Fault)
+}
+
+// ChaosRule decides which commands a fault is injected into. A command gets the fault when it
+// matches Commands and either its Probability, Every or Calls trigger. If none of them are set
+// the fault is injected into every matching command
+type ChaosRule struct {
+	// Fault is the fault to inject
+	Fault Fault
+
+	// Commands are the commands the rule applies to, matched the same way as the Allow
+	// rules of a Policy. No commands applies the rule to every command
+	Commands []PolicyRule
+
+	// Probability is the chance, from 0 to 1, that a matching command gets the fault
+	Probability float64
+
+	// Every injects the fault into every nth matching command
+	Every int
+
+	// Calls are the matching commands, counted from 1, that get the fault
+	Calls []int
+
+	// ExitCode is the exit code of FaultExit, the default is 1
+	ExitCode int
+
+	// Delay is how long output is held back by FaultDelay and how long a command runs
+	// before FaultKill kills it, the default is 100ms
+	Delay time.Duration
+
+	// Bytes is the number of bytes of output FaultTruncate keeps, the default is half of the
+	// output, and the number of bytes FaultCorrupt changes, the default is 1
+	Bytes int
+}
+
+// InjectedFault is a fault a Chaos injected into a command
+type InjectedFault struct {
+	Fault Fault
+	Args  []string
+}
+
+// Chaos injects faults into commands, so error handling can be tested against a real Exec.
+// Faults are picked with a seeded random source so a failing run can be reproduced
+type Chaos struct {
+	rules    []ChaosRule
+	policies []*Policy
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	counts   []int
+	injected []InjectedFault
+}
+
+// NewChaos creates a Chaos that injects faults according to the rules. When a command triggers
+// several rules, the first one is used. The seed makes the random choices repeatable
+func NewChaos(seed int64, rules ...ChaosRule) *Chaos {
+	c := &Chaos{
+		rules:  rules,
+		rand:   rand.New(rand.NewSource(seed)),
+		counts: make([]int, len(rules)),
+	}
+	for _, rule := range rules {
+		c.policies = append(c.policies, &Policy{Allow: rule.Commands})
+	}
+
+	return c
+}
+
+// Middleware returns a Middleware that injects faults into commands when they are started
+func (c *Chaos) Middleware() Middleware {
+	return func(base Exec) Exec {
+		return &chaosExec{base: base, chaos: c}
+	}
+}
+
+// Injected returns the faults that have been injected, in the order the commands were started
+func (c *Chaos) Injected() []InjectedFault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]InjectedFault(nil), c.injected...)
+}
+
+// pick returns the rule whose fault is injected into the command, or nil
+func (c *Chaos) pick(cmd Cmd) *ChaosRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var picked *ChaosRule
+	for i := range c.rules {
+		if c.policies[i].Check(cmd) != nil {
+			continue
+		}
+		c.counts[i]++
+
+		// every matching rule is counted and draws a random number, even after a rule has
+		// been picked, so the rules don't change each others schedules
+		if c.triggered(&c.rules[i], c.counts[i]) && picked == nil {
+			picked = &c.rules[i]
+		}
+	}
+
+	if picked != nil {
+		c.injected = append(c.injected, InjectedFault{
+			Fault: picked.Fault,
+			Args:  append([]string(nil), cmd.Args()...),
+		})
+	}
+	return picked
+}
+
+// triggered reports whether the rule triggers for its nth matching command. c.mu must be held
+func (c *Chaos) triggered(rule *ChaosRule, n int) bool {
+	roll := c.rand.Float64()
+	if rule.Probability == 0 && rule.Every == 0 && len(rule.Calls) == 0 {
+		return true
+	}
+
+	if roll < rule.Probability {
+		return true
+	}
+	if rule.Every > 0 && n%rule.Every == 0 {
+		return true
+	}
+	for _, call := range rule.Calls {
+		if call == n {
+			return true
+		}
+	}
+	return false
+}
+
+// corrupt flips the bits of n random bytes of data
+func (c *Chaos) corrupt(data []byte, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := 0; i < n && len(data) > 0; i++ {
+		data[c.rand.Intn(len(data))] ^= 0xff
+	}
+}
+
+// chaosExec is an Exec that injects faults into the commands created by the base Exec
+type chaosExec struct {
+	base  Exec
+	chaos *Chaos
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *chaosExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that may have a fault injected when it's started
+func (e *chaosExec) Command(name string, arg ...string) Cmd {
+	return e.shim(nil, e.base.Command(name, arg...))
+}
+
+// CommandContext creates a Cmd that may have a fault injected when it's started
+func (e *chaosExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return e.shim(ctx, e.base.CommandContext(ctx, name, arg...))
+}
+
+// shim creates a shimCmd that picks its fault when it's started
+func (e *chaosExec) shim(ctx context.Context, cmd Cmd) Cmd {
+	var rule *ChaosRule
+	s := newShimCmd(ctx, cmd, func(s *shimCmd, stdin io.Reader, stdout, stderr io.Writer) error {
+		return e.run(s, rule, stdin, stdout, stderr)
+	})
+	s.prepare = func() error {
+		rule = e.chaos.pick(cmd)
+		if rule != nil && rule.Fault == FaultStart {
+			return &FaultError{Fault: FaultStart}
+		}
+		return nil
+	}
+
+	return s
+}
+
+// run runs the command with the fault of the rule injected
+func (e *chaosExec) run(s *shimCmd, rule *ChaosRule, stdin io.Reader, stdout, stderr io.Writer) error {
+	if rule == nil {
+		return s.runClone(s.ctx, e.base, stdin, stdout, stderr)
+	}
+
+	delay := rule.Delay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	ctx := s.context()
+
+	switch rule.Fault {
+	case FaultExit:
+		code := rule.ExitCode
+		if code == 0 {
+			code = 1
+		}
+		return &exitError{code: code}
+
+	case FaultHang:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stop:
+			return errStopped
+		}
+
+	case FaultKill:
+		killCtx, kill := context.WithCancel(ctx)
+		defer kill()
+		timer := time.AfterFunc(delay, kill)
+		defer timer.Stop()
+
+		err := s.runClone(killCtx, e.base, stdin, stdout, stderr)
+		if killCtx.Err() != nil && ctx.Err() == nil {
+			return &FaultError{Fault: FaultKill}
+		}
+		return err
+
+	default:
+		// stderr is held back with stdout so combined output keeps its order
+		rec := &chaosOutput{}
+		err := s.runClone(s.ctx, e.base, stdin, rec.writer(false), rec.writer(true))
+		out := rec.stdout()
+
+		switch rule.Fault {
+		case FaultTruncate:
+			n := rule.Bytes
+			if n <= 0 {
+				n = len(out) / 2
+			}
+			if n < len(out) {
+				out = out[:n]
+			}
+		case FaultCorrupt:
+			n := rule.Bytes
+			if n <= 0 {
+				n = 1
+			}
+			e.chaos.corrupt(out, n)
+		case FaultDelay:
+			if sleepErr := s.sleep(delay); sleepErr != nil {
+				return sleepErr
+			}
+		}
+
+		rec.writeTo(stdout, stderr, out)
+		return err
+	}
+}
+
+// chaosOutput records the output of a command in the order it was written,
+// so a fault can change standard output before the output is passed on
+type chaosOutput struct {
+	mu     sync.Mutex
+	chunks []chaosChunk
+}
+
+// chaosChunk is a single write to standard output or standard error
+type chaosChunk struct {
+	stderr bool
+	data   []byte
+}
+
+// writer returns a writer that records writes to standard output, or standard error
+func (o *chaosOutput) writer(stderr bool) io.Writer {
+	return &chaosWriter{out: o, stderr: stderr}
+}
+
+// chaosWriter records the writes to one of the outputs of a chaosOutput
+type chaosWriter struct {
+	out    *chaosOutput
+	stderr bool
+}
+
+func (w *chaosWriter) Write(p []byte) (int, error) {
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+
+	w.out.chunks = append(w.out.chunks, chaosChunk{stderr: w.stderr, data: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+// stdout returns all the recorded standard output
+func (o *chaosOutput) stdout() []byte {
+	var out []byte
+	for _, chunk := range o.chunks {
+		if !chunk.stderr {
+			out = append(out, chunk.data...)
+		}
+	}
+	return out
+}
+
+// writeTo writes the recorded output in order, with out in place of the recorded
+// standard output. If out is shorter the rest of standard output is dropped
+func (o *chaosOutput) writeTo(stdout, stderr io.Writer, out []byte) {
+	for _, chunk := range o.chunks {
+		if chunk.stderr {
+			stderr.Write(chunk.data)
+			continue
+		}
+
+		n := len(chunk.data)
+		if n > len(out) {
+			n = len(out)
+		}
+		if n > 0 {
+			stdout.Write(out[:n])
+		}
+		out = out[n:]
+	}
+}
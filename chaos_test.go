@@ -0,0 +1,225 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     ChaosRule
+		wantOut  string
+		wantErr  func(err error) bool
+		minDelay time.Duration
+	}{
+		{
+			"no fault",
+			ChaosRule{Fault: FaultExit, Commands: []PolicyRule{{Command: "wget"}}},
+			"hello world",
+			func(err error) bool { return err == nil },
+			0,
+		},
+		{
+			"start",
+			ChaosRule{Fault: FaultStart},
+			"",
+			func(err error) bool {
+				var fault *FaultError
+				return errors.As(err, &fault) && fault.Fault == FaultStart
+			},
+			0,
+		},
+		{
+			"exit",
+			ChaosRule{Fault: FaultExit, ExitCode: 7},
+			"",
+			func(err error) bool { return ExitCode(err) == 7 },
+			0,
+		},
+		{
+			"truncate",
+			ChaosRule{Fault: FaultTruncate, Bytes: 5},
+			"hello",
+			func(err error) bool { return err == nil },
+			0,
+		},
+		{
+			"truncate by default",
+			ChaosRule{Fault: FaultTruncate},
+			"hello",
+			func(err error) bool { return err == nil },
+			0,
+		},
+		{
+			"delay",
+			ChaosRule{Fault: FaultDelay, Delay: 20 * time.Millisecond},
+			"hello world",
+			func(err error) bool { return err == nil },
+			20 * time.Millisecond,
+		},
+		{
+			"hang",
+			ChaosRule{Fault: FaultHang},
+			"",
+			func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+			50 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{
+					"curl": Respond("hello world", "", 0),
+				})),
+				NewChaos(1, tt.rule).Middleware(),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			out, err := exec.CommandContext(ctx, "curl", "example.com").Output()
+			if !tt.wantErr(err) {
+				t.Errorf("Output() error = %v", err)
+			}
+			if string(out) != tt.wantOut {
+				t.Errorf("Output() = %q, want %q", out, tt.wantOut)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("Output() took %v, want at least %v", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestChaos_corrupt(t *testing.T) {
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"curl": Respond("hello world", "", 0),
+		})),
+		NewChaos(1, ChaosRule{Fault: FaultCorrupt, Bytes: 2}).Middleware(),
+	)
+
+	out, err := exec.Command("curl").Output()
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	if len(out) != len("hello world") || string(out) == "hello world" {
+		t.Errorf("Output() = %q, want corrupted output of the same length", out)
+	}
+}
+
+func TestChaos_combinedOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ChaosRule
+		want string
+	}{
+		{"delay", ChaosRule{Fault: FaultDelay, Delay: time.Millisecond}, "out1 err1 out2 "},
+		{"truncate", ChaosRule{Fault: FaultTruncate, Bytes: 7}, "out1 err1 ou"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := Wrap(
+				NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+					fmt.Fprint(fc.Stdout(), "out1 ")
+					fmt.Fprint(fc.Stderr(), "err1 ")
+					fmt.Fprint(fc.Stdout(), "out2 ")
+					return 0
+				})),
+				NewChaos(1, tt.rule).Middleware(),
+			)
+
+			out, err := exec.Command("curl").CombinedOutput()
+			if err != nil {
+				t.Fatalf("CombinedOutput() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("CombinedOutput() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestChaos_schedule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ChaosRule
+		want []bool
+	}{
+		{"every", ChaosRule{Fault: FaultExit, Every: 3}, []bool{false, false, true, false, false, true}},
+		{"calls", ChaosRule{Fault: FaultExit, Calls: []int{1, 4}}, []bool{true, false, false, true, false, false}},
+		{"always", ChaosRule{Fault: FaultExit}, []bool{true, true, true}},
+		{"never", ChaosRule{Fault: FaultExit, Probability: 0.0001}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chaos := NewChaos(1, tt.rule)
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{"curl": Respond("", "", 0)})),
+				chaos.Middleware(),
+			)
+
+			for i, want := range tt.want {
+				err := exec.Command("curl").Run()
+				if got := err != nil; got != want {
+					t.Errorf("call %d: Run() error = %v, want fault %v", i+1, err, want)
+				}
+			}
+
+			want := 0
+			for _, w := range tt.want {
+				if w {
+					want++
+				}
+			}
+			if got := len(chaos.Injected()); got != want {
+				t.Errorf("Injected() has %d faults, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestChaos_probability(t *testing.T) {
+	chaos := NewChaos(42, ChaosRule{Fault: FaultExit, Probability: 0.5})
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{"curl": Respond("", "", 0)})),
+		chaos.Middleware(),
+	)
+
+	failed := 0
+	for i := 0; i < 200; i++ {
+		if exec.Command("curl").Run() != nil {
+			failed++
+		}
+	}
+	if failed < 60 || failed > 140 {
+		t.Errorf("%d of 200 commands failed, want about half", failed)
+	}
+}
+
+func TestChaos_kill(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	oExec := Wrap(NewOsExec(), NewChaos(1, ChaosRule{Fault: FaultKill, Delay: 50 * time.Millisecond}).Middleware())
+
+	start := time.Now()
+	out, err := oExec.Command("sh", "-c", "echo started; exec sleep 5").Output()
+	var fault *FaultError
+	if !errors.As(err, &fault) || fault.Fault != FaultKill {
+		t.Errorf("Output() error = %v, want an injected kill", err)
+	}
+	if string(out) != "started\n" {
+		t.Errorf("Output() = %q, want %q", out, "started\n")
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("Output() took %v, the command was not killed", elapsed)
+	}
+}
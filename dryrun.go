@@ -0,0 +1,274 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bjatkin/puffin/internal/words"
+)
+
+// PlannedCmd is a command that a DryRunExec would have run
+type PlannedCmd struct {
+	// Args are the command line arguments, including the command
+	Args []string
+
+	// Dir is the working directory of the command
+	Dir string
+
+	// Env are the key=value pairs the command adds or changes
+	// compared to the environment of the current process
+	Env []string
+
+	// Unset are the names of env vars from the current process that the command does not have
+	Unset []string
+
+	// Stdin is the start of the commands standard input
+	Stdin string
+
+	// StdinTruncated reports whether there was more standard input than is in Stdin
+	StdinTruncated bool
+}
+
+// String returns the planned command as a shell quoted command line followed by
+// the dir, env changes and stdin preview, if there are any
+func (p PlannedCmd) String() string {
+	return p.format(nil)
+}
+
+// format formats the planned command, if r is not nil secrets are redacted
+func (p PlannedCmd) format(r *Redactor) string {
+	args, env, stdin := p.Args, p.Env, p.Stdin
+	if r != nil {
+		args, env, stdin = r.Args(args), r.Env(env), r.String(stdin)
+	}
+
+	var b strings.Builder
+	b.WriteString("+ " + words.Join(args))
+	if p.Dir != "" {
+		b.WriteString("\n  dir: " + p.Dir)
+	}
+	if len(env) > 0 {
+		b.WriteString("\n  env: " + words.Join(env))
+	}
+	if len(p.Unset) > 0 {
+		b.WriteString("\n  unset: " + strings.Join(p.Unset, " "))
+	}
+	if stdin != "" {
+		fmt.Fprintf(&b, "\n  stdin: %q", stdin)
+		if p.StdinTruncated {
+			b.WriteString("...")
+		}
+	}
+
+	return b.String()
+}
+
+// DryRunExec is an Exec that records a plan of the commands it would run instead of running them.
+// Commands succeed with no output unless other results are configured, so the calling program
+// keeps flowing. A command is added to the plan when it is started
+type DryRunExec struct {
+	fExec *FuncExec
+
+	mu   sync.Mutex
+	plan []PlannedCmd
+
+	out          io.Writer
+	redactor     *Redactor
+	stdinPreview int
+	result       CmdFunc
+	funcs        map[string]CmdFunc
+	baseEnv      map[string]string
+}
+
+// DryRunOption configures a DryRunExec
+type DryRunOption func(*DryRunExec)
+
+// WithPlanOutput prints every planned command to w as it is started.
+// Secrets are redacted with the DefaultRedactor
+func WithPlanOutput(w io.Writer) DryRunOption {
+	return func(e *DryRunExec) {
+		e.out = w
+	}
+}
+
+// WithDryRunResult sets the result of every planned command, the default is
+// Respond("", "", 0)
+func WithDryRunResult(fn CmdFunc) DryRunOption {
+	return func(e *DryRunExec) {
+		e.result = fn
+	}
+}
+
+// WithDryRunFuncs sets the results of specific commands, e.g. so a planned `git rev-parse HEAD`
+// still returns a commit hash. Commands are matched the same way as FuncExec commands
+func WithDryRunFuncs(funcs map[string]CmdFunc) DryRunOption {
+	return func(e *DryRunExec) {
+		e.funcs = funcs
+	}
+}
+
+// WithStdinPreview sets the number of bytes of standard input that are included in the plan.
+// The default is 256 bytes, 0 disables the stdin preview
+func WithStdinPreview(n int) DryRunOption {
+	return func(e *DryRunExec) {
+		e.stdinPreview = n
+	}
+}
+
+// NewDryRunExec creates a new DryRunExec
+func NewDryRunExec(opts ...DryRunOption) *DryRunExec {
+	e := &DryRunExec{
+		redactor:     DefaultRedactor(),
+		stdinPreview: 256,
+		result:       Respond("", "", 0),
+		baseEnv:      map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		e.baseEnv[name] = value
+	}
+
+	funcs := make(map[string]CmdFunc, len(e.funcs))
+	for name, fn := range e.funcs {
+		funcs[name] = e.record(fn)
+	}
+
+	e.fExec = &FuncExec{
+		funcMap:     funcs,
+		defaultFunc: e.record(e.result),
+		envs:        e.baseEnv,
+	}
+
+	return e
+}
+
+// LookPath always succeeds since every command is planned rather than run
+func (e *DryRunExec) LookPath(file string) (string, error) {
+	return e.fExec.LookPath(file)
+}
+
+// Command creates a Cmd that is added to the plan when it is started
+func (e *DryRunExec) Command(name string, arg ...string) Cmd {
+	return e.fExec.Command(name, arg...)
+}
+
+// CommandContext creates a Cmd that is added to the plan when it is started
+func (e *DryRunExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return e.fExec.CommandContext(ctx, name, arg...)
+}
+
+// Plan returns the commands that have been started, in the order they were started
+func (e *DryRunExec) Plan() []PlannedCmd {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]PlannedCmd(nil), e.plan...)
+}
+
+// Reset clears the plan
+func (e *DryRunExec) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.plan = nil
+}
+
+// record returns a CmdFunc that adds the command to the plan before running fn
+func (e *DryRunExec) record(fn CmdFunc) CmdFunc {
+	return func(fc *FuncCmd) int {
+		planned := PlannedCmd{
+			Args: append([]string(nil), fc.Args()...),
+			Dir:  fc.Dir(),
+		}
+		planned.Env, planned.Unset = e.envDiff(fc.Environ())
+		planned.Stdin, planned.StdinTruncated = e.previewStdin(fc)
+
+		e.mu.Lock()
+		e.plan = append(e.plan, planned)
+		if e.out != nil {
+			fmt.Fprintln(e.out, planned.format(e.redactor))
+		}
+		e.mu.Unlock()
+
+		return fn(fc)
+	}
+}
+
+// envDiff returns the env vars that were added or changed and the names of the
+// env vars that were removed compared to the environment of the current process
+func (e *DryRunExec) envDiff(env []string) ([]string, []string) {
+	var changed []string
+	seen := map[string]bool{}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		seen[name] = true
+		if base, ok := e.baseEnv[name]; !ok || base != value {
+			changed = append(changed, kv)
+		}
+	}
+
+	var unset []string
+	for name := range e.baseEnv {
+		if !seen[name] {
+			unset = append(unset, name)
+		}
+	}
+
+	sort.Strings(changed)
+	sort.Strings(unset)
+	return changed, unset
+}
+
+// previewStdin reads the start of the commands standard input. The input that was
+// read is put back so the result func can still read all of it. Only input that can be
+// read without blocking the dry run is previewed, see canPreview
+func (e *DryRunExec) previewStdin(fc *FuncCmd) (string, bool) {
+	stdin := fc.Stdin()
+	if stdin == nil || e.stdinPreview <= 0 || !canPreview(stdin) {
+		return "", false
+	}
+
+	buf := make([]byte, e.stdinPreview+1)
+	n := 0
+	for n < len(buf) {
+		// stop on a read of 0 bytes as well as an error, a locked reader never returns an error
+		read, err := stdin.Read(buf[n:])
+		n += read
+		if read == 0 || err != nil {
+			break
+		}
+	}
+	buf = buf[:n]
+	fc.SetStdin(io.MultiReader(strings.NewReader(string(buf)), stdin))
+
+	if n > e.stdinPreview {
+		return string(buf[:e.stdinPreview]), true
+	}
+	return string(buf), false
+}
+
+// canPreview reports whether r can be read without blocking. In memory readers and regular
+// files can be, pipes, terminals and other readers may wait for input that never comes
+func canPreview(r io.Reader) bool {
+	switch r := r.(type) {
+	case *lockableBuffer:
+		return r.reader != nil && canPreview(r.reader)
+	case *bytes.Reader, *strings.Reader, *bytes.Buffer:
+		return true
+	case *os.File:
+		info, err := r.Stat()
+		return err == nil && info.Mode().IsRegular()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package puffin
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRunExec(t *testing.T) {
+	var out bytes.Buffer
+	exec := NewDryRunExec(
+		WithPlanOutput(&out),
+		WithStdinPreview(5),
+		WithDryRunFuncs(map[string]CmdFunc{
+			"git": Respond("abc123\n", "", 0),
+		}),
+	)
+
+	rev, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil || string(rev) != "abc123\n" {
+		t.Fatalf("Output() = %q, %v, want the configured result", rev, err)
+	}
+
+	deploy := exec.Command("kubectl", "apply", "--token", "s3cret", "-f", "my manifest.yaml")
+	deploy.SetDir("/srv/app")
+	deploy.SetEnv(append(os.Environ(), "KUBECONFIG=/tmp/kube config"))
+	deploy.SetStdin(strings.NewReader("apiVersion: v1"))
+	if err := deploy.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []PlannedCmd{
+		{Args: []string{"git", "rev-parse", "HEAD"}},
+		{
+			Args:           []string{"kubectl", "apply", "--token", "s3cret", "-f", "my manifest.yaml"},
+			Dir:            "/srv/app",
+			Env:            []string{"KUBECONFIG=/tmp/kube config"},
+			Stdin:          "apiVe",
+			StdinTruncated: true,
+		},
+	}
+	if got := exec.Plan(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DryRunExec.Plan() = %+v, want %+v", got, want)
+	}
+
+	wantOut := "+ git rev-parse HEAD\n" +
+		"+ kubectl apply --token '[REDACTED]' -f 'my manifest.yaml'\n" +
+		"  dir: /srv/app\n" +
+		"  env: 'KUBECONFIG=/tmp/kube config'\n" +
+		"  stdin: \"apiVe\"...\n"
+	if out.String() != wantOut {
+		t.Errorf("DryRunExec printed\n%s\nwant\n%s", out.String(), wantOut)
+	}
+
+	exec.Reset()
+	if len(exec.Plan()) != 0 {
+		t.Errorf("DryRunExec.Plan() is not empty after Reset")
+	}
+}
+
+func TestDryRunExec_stdin(t *testing.T) {
+	exec := NewDryRunExec(WithDryRunResult(func(fc *FuncCmd) int {
+		// the result func still sees all of stdin after the preview was taken
+		data, _ := io.ReadAll(fc.Stdin())
+		orDiscard(fc.Stdout()).Write(data)
+		return 3
+	}))
+
+	cmd := exec.Command("cat")
+	cmd.SetStdin(strings.NewReader("hello world"))
+	out, err := cmd.Output()
+	if ExitCode(err) != 3 || string(out) != "hello world" {
+		t.Errorf("Output() = %q, %v, want the full stdin and exit code 3", out, err)
+	}
+
+	cmd = exec.Command("cat")
+	cmd.SetStdin(os.Stdin)
+	if err := cmd.Run(); ExitCode(err) != 3 {
+		t.Errorf("Run() error = %v, want exit code 3", err)
+	}
+
+	plan := exec.Plan()
+	if plan[0].Stdin != "hello world" || plan[0].StdinTruncated || plan[1].Stdin != "" {
+		t.Errorf("DryRunExec.Plan() = %+v", plan)
+	}
+}
+
+func TestDryRunExec_stdinPipe(t *testing.T) {
+	exec := NewDryRunExec()
+
+	// nothing is ever written to the pipe, reading a preview from it would block
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	cmd := exec.Command("cat")
+	cmd.SetStdin(pr)
+
+	done := make(chan error)
+	go func() { done <- cmd.Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return, the stdin preview is waiting for the pipe")
+	}
+
+	if plan := exec.Plan(); plan[0].Stdin != "" || plan[0].StdinTruncated {
+		t.Errorf("DryRunExec.Plan() = %+v, want no stdin preview", plan)
+	}
+}
@@ -0,0 +1,240 @@
+package puffin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+)
+
+// ElevationMethod is how an Elevator runs a command with elevated privileges
+type ElevationMethod string
+
+const (
+	// ElevateSudo runs the command with sudo -n, so it fails rather than prompting for a password
+	ElevateSudo ElevationMethod = "sudo"
+
+	// ElevateDoas runs the command with doas -n, so it fails rather than prompting for a password
+	ElevateDoas ElevationMethod = "doas"
+
+	// ElevateCredential runs the command as the target user by setting SysProcAttr.Credential.
+	// It's used instead of sudo or doas when the current process is running as root
+	ElevateCredential ElevationMethod = "credential"
+)
+
+// ElevationDecision records whether an Elevator elevated a command
+type ElevationDecision struct {
+	// Args are the command line arguments of the command before it was elevated
+	Args []string
+
+	// Elevated reports whether the command needed elevated privileges
+	Elevated bool
+
+	// Method is how the command was elevated, it's empty if the command was not elevated
+	Method ElevationMethod
+
+	// User is the user the command was run as, it's empty if the command was not elevated
+	User string
+}
+
+// Elevator runs the commands that match its rules with elevated privileges. Commands are
+// rewritten to run with sudo or doas, with the original arguments passed through unchanged
+// so no shell quoting is involved
+type Elevator struct {
+	policy *Policy
+	method ElevationMethod
+	user   string
+	fake   bool
+	euid   func() int
+
+	mu        sync.Mutex
+	decisions []ElevationDecision
+}
+
+// ElevateOption configures an Elevator
+type ElevateOption func(*Elevator)
+
+// WithElevationMethod sets the program used to elevate commands when the current process is
+// not running as root, the default is ElevateSudo
+func WithElevationMethod(method ElevationMethod) ElevateOption {
+	return func(e *Elevator) {
+		e.method = method
+	}
+}
+
+// WithElevationUser sets the user elevated commands run as, the default is root
+func WithElevationUser(name string) ElevateOption {
+	return func(e *Elevator) {
+		e.user = name
+	}
+}
+
+// WithFakeElevation records elevation decisions without changing the commands, so the
+// decisions can be checked with Decisions in tests that use a FuncExec
+func WithFakeElevation() ElevateOption {
+	return func(e *Elevator) {
+		e.fake = true
+	}
+}
+
+// NewElevator creates an Elevator for the commands that match any of the rules,
+// rules are matched the same way as the Allow rules of a Policy. No rules elevates nothing
+func NewElevator(rules []PolicyRule, opts ...ElevateOption) *Elevator {
+	e := &Elevator{
+		policy: &Policy{Allow: rules},
+		method: ElevateSudo,
+		user:   "root",
+		euid:   os.Geteuid,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Middleware returns a Middleware that elevates commands when they are started. Commands that
+// are elevated with sudo or doas have their path and args replaced, and the environment they
+// run with is decided by the sudo or doas configuration
+func (e *Elevator) Middleware() Middleware {
+	return func(base Exec) Exec {
+		return &elevateExec{base: base, elevator: e}
+	}
+}
+
+// Decisions returns the decisions made in fake mode, in the order the commands were started
+func (e *Elevator) Decisions() []ElevationDecision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]ElevationDecision(nil), e.decisions...)
+}
+
+// decide decides if and how the command is elevated
+func (e *Elevator) decide(cmd Cmd) ElevationDecision {
+	decision := ElevationDecision{Args: append([]string(nil), cmd.Args()...)}
+	if len(e.policy.Allow) == 0 || e.policy.Check(cmd) != nil {
+		return decision
+	}
+
+	// commands that already elevate themselves are left alone
+	switch filepath.Base(cmd.Path()) {
+	case string(ElevateSudo), string(ElevateDoas):
+		return decision
+	}
+
+	decision.Elevated = true
+	decision.User = e.user
+	decision.Method = e.method
+	if e.euid() == 0 {
+		decision.Method = ElevateCredential
+	}
+
+	return decision
+}
+
+// elevate rewrites cmd according to the decision
+func (e *Elevator) elevate(base Exec, cmd Cmd, decision ElevationDecision) error {
+	if decision.Method == ElevateCredential {
+		u, err := user.Lookup(decision.User)
+		if err != nil {
+			return err
+		}
+
+		setter, ok := findCmd[sysProcAttrSetter](cmd)
+		if !ok {
+			return fmt.Errorf("puffin: can not set the credential of %s", cmd.Path())
+		}
+		attr, err := withCredential(cmd.SysProcAttr(), u)
+		if err != nil {
+			return err
+		}
+		setter.SetSysProcAttr(attr)
+		return nil
+	}
+
+	path, err := base.LookPath(string(decision.Method))
+	if err != nil {
+		return err
+	}
+
+	args := []string{string(decision.Method), "-n"}
+	if decision.User != "root" {
+		args = append(args, "-u", decision.User)
+	}
+	args = append(args, "--", cmd.Path())
+	if len(decision.Args) > 1 {
+		args = append(args, decision.Args[1:]...)
+	}
+
+	cmd.SetPath(path)
+	cmd.SetArgs(args)
+	return nil
+}
+
+// elevateExec is an Exec that elevates the commands created by the base Exec
+type elevateExec struct {
+	base     Exec
+	elevator *Elevator
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *elevateExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that is elevated when it's started if it matches the rules
+func (e *elevateExec) Command(name string, arg ...string) Cmd {
+	return &elevateCmd{Cmd: e.base.Command(name, arg...), exec: e}
+}
+
+// CommandContext creates a Cmd that is elevated when it's started if it matches the rules
+func (e *elevateExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return &elevateCmd{Cmd: e.base.CommandContext(ctx, name, arg...), exec: e}
+}
+
+// elevateCmd is a Cmd that is elevated when it's started
+type elevateCmd struct {
+	Cmd
+	exec *elevateExec
+}
+
+// Unwrap returns the Cmd that is elevated
+func (c *elevateCmd) Unwrap() Cmd {
+	return c.Cmd
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error
+func (c *elevateCmd) CombinedOutput() ([]byte, error) {
+	return combinedOutput(c)
+}
+
+// Output runs the command and returns its standard output
+func (c *elevateCmd) Output() ([]byte, error) {
+	return output(c)
+}
+
+// Run starts the command and waits for it to complete
+func (c *elevateCmd) Run() error {
+	return run(c)
+}
+
+// Start elevates the command if it matches the rules and starts it
+func (c *elevateCmd) Start() error {
+	elevator := c.exec.elevator
+	decision := elevator.decide(c.Cmd)
+
+	if elevator.fake {
+		elevator.mu.Lock()
+		elevator.decisions = append(elevator.decisions, decision)
+		elevator.mu.Unlock()
+	} else if decision.Elevated {
+		if err := elevator.elevate(c.exec.base, c.Cmd, decision); err != nil {
+			return err
+		}
+	}
+
+	return c.Cmd.Start()
+}
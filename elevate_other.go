@@ -0,0 +1,15 @@
+//go:build !unix
+
+package puffin
+
+import (
+	"fmt"
+	"os/user"
+	"runtime"
+	"syscall"
+)
+
+// withCredential returns an error since processes can't be run as another user on this platform
+func withCredential(attr *syscall.SysProcAttr, u *user.User) (*syscall.SysProcAttr, error) {
+	return nil, fmt.Errorf("puffin: running commands as %s is not supported on %s", u.Username, runtime.GOOS)
+}
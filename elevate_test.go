@@ -0,0 +1,95 @@
+package puffin
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestElevator(t *testing.T) {
+	echo := func(fc *FuncCmd) int {
+		fc.Stdout().Write([]byte(fmt.Sprintf("%q", fc.Args())))
+		return 0
+	}
+	rules := []PolicyRule{{Command: "apt-get"}, {Command: "systemctl", Args: []string{"restart", "..."}}}
+
+	tests := []struct {
+		name string
+		opts []ElevateOption
+		args []string
+		want []string
+	}{
+		{
+			"sudo",
+			nil,
+			[]string{"apt-get", "install", "-y", "name with spaces"},
+			[]string{"sudo", "-n", "--", "apt-get", "install", "-y", "name with spaces"},
+		},
+		{
+			"doas as user",
+			[]ElevateOption{WithElevationMethod(ElevateDoas), WithElevationUser("postgres")},
+			[]string{"systemctl", "restart", "postgresql"},
+			[]string{"doas", "-n", "-u", "postgres", "--", "systemctl", "restart", "postgresql"},
+		},
+		{
+			"not matched",
+			nil,
+			[]string{"systemctl", "status"},
+			[]string{"systemctl", "status"},
+		},
+		{
+			"already elevated",
+			nil,
+			[]string{"sudo", "apt-get", "update"},
+			[]string{"sudo", "apt-get", "update"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elevator := NewElevator(rules, tt.opts...)
+			elevator.euid = func() int { return 1000 }
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{
+					"sudo": echo, "doas": echo, "apt-get": echo, "systemctl": echo,
+				})),
+				elevator.Middleware(),
+			)
+
+			out, err := exec.Command(tt.args[0], tt.args[1:]...).Output()
+			if err != nil {
+				t.Fatalf("Output() error = %v", err)
+			}
+			if want := fmt.Sprintf("%q", tt.want); string(out) != want {
+				t.Errorf("ran %s, want %s", out, want)
+			}
+		})
+	}
+}
+
+func TestElevator_fake(t *testing.T) {
+	elevator := NewElevator([]PolicyRule{{Command: "apt-get"}}, WithFakeElevation())
+	elevator.euid = func() int { return 1000 }
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"apt-get": Respond("installed", "", 0),
+			"uname":   Respond("Linux", "", 0),
+		})),
+		elevator.Middleware(),
+	)
+
+	if out, _ := exec.Command("apt-get", "install", "curl").Output(); string(out) != "installed" {
+		t.Errorf("Output() = %q, want the command to run unchanged", out)
+	}
+	exec.Command("uname").Run()
+
+	decisions := elevator.Decisions()
+	if len(decisions) != 2 {
+		t.Fatalf("Decisions() = %v, want 2 decisions", decisions)
+	}
+	if d := decisions[0]; !d.Elevated || d.Method != ElevateSudo || d.User != "root" || strings.Join(d.Args, " ") != "apt-get install curl" {
+		t.Errorf("Decisions()[0] = %+v, want apt-get elevated with sudo", d)
+	}
+	if d := decisions[1]; d.Elevated || d.Method != "" {
+		t.Errorf("Decisions()[1] = %+v, want uname not elevated", d)
+	}
+}
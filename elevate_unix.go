@@ -0,0 +1,43 @@
+//go:build unix
+
+package puffin
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// withCredential returns a copy of attr that runs the process as the user, with the user's
+// supplementary groups rather than the groups of the current process
+func withCredential(attr *syscall.SysProcAttr, u *user.User) (*syscall.SysProcAttr, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("puffin: looking up the groups of %s: %w", u.Username, err)
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		group, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(group))
+	}
+
+	copied := &syscall.SysProcAttr{}
+	if attr != nil {
+		*copied = *attr
+	}
+	copied.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+	return copied, nil
+}
@@ -0,0 +1,100 @@
+//go:build unix
+
+package puffin
+
+import (
+	"os/user"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestElevator_credential(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("can not look up the current user: %v", err)
+	}
+
+	elevator := NewElevator([]PolicyRule{{Command: "apt-get"}}, WithElevationUser(current.Username))
+	elevator.euid = func() int { return 0 }
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{"apt-get": Respond("", "", 0)})),
+		elevator.Middleware(),
+	)
+
+	cmd := exec.Command("apt-get", "update")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if cmd.Args()[0] != "apt-get" {
+		t.Errorf("Args() = %v, want the command to run without sudo", cmd.Args())
+	}
+	attr := cmd.SysProcAttr()
+	if attr == nil || attr.Credential == nil || strconv.Itoa(int(attr.Credential.Uid)) != current.Uid {
+		t.Fatalf("SysProcAttr() = %+v, want the credential of %s", attr, current.Username)
+	}
+	if groups, err := current.GroupIds(); err == nil && len(attr.Credential.Groups) != len(groups) {
+		t.Errorf("Credential.Groups = %v, want the groups of %s %v", attr.Credential.Groups, current.Username, groups)
+	}
+}
+
+func TestWithCredential(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("can not look up the current user: %v", err)
+	}
+	groupIDs, err := current.GroupIds()
+	if err != nil {
+		t.Skipf("can not look up the groups of the current user: %v", err)
+	}
+	var wantGroups []uint32
+	for _, id := range groupIDs {
+		group, _ := strconv.ParseUint(id, 10, 32)
+		wantGroups = append(wantGroups, uint32(group))
+	}
+
+	tests := []struct {
+		name string
+		attr *syscall.SysProcAttr
+		user *user.User
+		want *syscall.SysProcAttr
+	}{
+		{
+			"no attr",
+			nil,
+			current,
+			&syscall.SysProcAttr{Credential: &syscall.Credential{Uid: parseID(current.Uid), Gid: parseID(current.Gid), Groups: wantGroups}},
+		},
+		{
+			"existing attr",
+			&syscall.SysProcAttr{Setpgid: true, Credential: &syscall.Credential{Uid: 1, Gid: 1, Groups: []uint32{1}}},
+			current,
+			&syscall.SysProcAttr{Setpgid: true, Credential: &syscall.Credential{Uid: parseID(current.Uid), Gid: parseID(current.Gid), Groups: wantGroups}},
+		},
+		{
+			"bad uid",
+			nil,
+			&user.User{Uid: "root", Gid: "0"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withCredential(tt.attr, tt.user)
+			if (err != nil) != (tt.want == nil) {
+				t.Fatalf("withCredential() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withCredential() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// parseID parses a user or group id
+func parseID(id string) uint32 {
+	n, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(n)
+}
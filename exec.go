@@ -9,11 +9,20 @@ import (
 )
 
 // OsExec is an Exec implementation that uses os/exec functions
-type OsExec struct{}
+type OsExec struct {
+	rlimits      []Rlimit
+	processGroup bool
+	stopSignal   os.Signal
+}
 
 // NewOsExec creates a new OsExec struct
-func NewOsExec() Exec {
-	return &OsExec{}
+func NewOsExec(opts ...OsExecOption) Exec {
+	e := &OsExec{}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
 }
 
 // Lookpath behaves the same as exec.LookPath https://pkg.go.dev/os/exec#LookPath
@@ -22,17 +31,33 @@ func (*OsExec) LookPath(file string) (string, error) {
 }
 
 // Command behaves the same as exec.Command https://pkg.go.dev/os/exec#Command
-func (*OsExec) Command(name string, arg ...string) Cmd {
-	return &OsCmd{Cmd: exec.Command(name, arg...)}
+func (e *OsExec) Command(name string, arg ...string) Cmd {
+	return &OsCmd{
+		Cmd:          exec.Command(name, arg...),
+		rlimits:      e.rlimits,
+		processGroup: e.processGroup,
+		stopSignal:   e.stopSignal,
+	}
 }
 
 // CommandContext behaves the same as exec.CommandContext https://pkg.go.dev/os/exec#CommandContext
-func (*OsExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
-	return &OsCmd{Cmd: exec.CommandContext(ctx, name, arg...)}
+func (e *OsExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return &OsCmd{
+		Cmd:          exec.CommandContext(ctx, name, arg...),
+		rlimits:      e.rlimits,
+		processGroup: e.processGroup,
+		stopSignal:   e.stopSignal,
+		ctx:          ctx,
+	}
 }
 
 type OsCmd struct {
 	*exec.Cmd
+	rlimits      []Rlimit
+	processGroup bool
+	stopSignal   os.Signal
+	ctx          context.Context
+	waitDone     chan struct{}
 }
 
 // Path returns the Cmd path https://pkg.go.dev/os/exec#Cmd
@@ -120,6 +145,11 @@ func (c *OsCmd) SysProcAttr() *syscall.SysProcAttr {
 	return c.Cmd.SysProcAttr
 }
 
+// SetSysProcAttr sets the Cmd sys proc attr https://pkg.go.dev/os/exec#Cmd
+func (c *OsCmd) SetSysProcAttr(attr *syscall.SysProcAttr) {
+	c.Cmd.SysProcAttr = attr
+}
+
 // Process returns the Cmd process https://pkg.go.dev/os/exec#Cmd
 func (c *OsCmd) Process() *os.Process {
 	return c.Cmd.Process
@@ -134,3 +164,82 @@ func (c *OsCmd) ProcessState() *os.ProcessState {
 func (c *OsCmd) Err() error {
 	return c.Cmd.Err
 }
+
+// managed reports whether the command needs to be started and waited on by OsCmd rather than
+// exec.Cmd, commands without resource limits or a process group behave exactly like exec.Cmd
+func (c *OsCmd) managed() bool {
+	return len(c.rlimits) > 0 || c.processGroup
+}
+
+// Start starts the command, see https://pkg.go.dev/os/exec#Cmd.Start.
+// Resource limits are applied before the program starts running
+func (c *OsCmd) Start() error {
+	if !c.managed() {
+		return c.Cmd.Start()
+	}
+
+	orig := c.Cmd.SysProcAttr
+	if c.processGroup {
+		c.Cmd.SysProcAttr = withProcessGroup(orig)
+	}
+
+	var err error
+	if len(c.rlimits) > 0 {
+		err = c.startLimited()
+	} else {
+		err = c.Cmd.Start()
+	}
+	c.Cmd.SysProcAttr = orig
+	if err != nil {
+		return err
+	}
+
+	c.watchContext()
+	return nil
+}
+
+// Wait waits for the command to exit, see https://pkg.go.dev/os/exec#Cmd.Wait.
+// If the process was killed because it went over its CPU limit the error is an *RlimitError.
+// Going over other limits makes system calls fail in the program, which reports it itself
+func (c *OsCmd) Wait() error {
+	err := c.Cmd.Wait()
+	if c.waitDone != nil {
+		close(c.waitDone)
+		c.waitDone = nil
+	}
+	// exec.Cmd only kills the process itself when the context is done, and Wait can return
+	// before watchContext has killed the rest of the group
+	if c.processGroup && c.ctx != nil && c.ctx.Err() != nil {
+		c.KillTree()
+	}
+
+	if err == nil || len(c.rlimits) == 0 {
+		return err
+	}
+	return c.limitError(err)
+}
+
+// Run starts the command and waits for it to complete, see https://pkg.go.dev/os/exec#Cmd.Run
+func (c *OsCmd) Run() error {
+	if !c.managed() {
+		return c.Cmd.Run()
+	}
+	return run(c)
+}
+
+// Output runs the command and returns its standard output, see https://pkg.go.dev/os/exec#Cmd.Output
+func (c *OsCmd) Output() ([]byte, error) {
+	if !c.managed() {
+		return c.Cmd.Output()
+	}
+	return output(c)
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error,
+// see https://pkg.go.dev/os/exec#Cmd.CombinedOutput
+func (c *OsCmd) CombinedOutput() ([]byte, error) {
+	if !c.managed() {
+		return c.Cmd.CombinedOutput()
+	}
+	return combinedOutput(c)
+}
@@ -0,0 +1,1012 @@
+package fakegit
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// command is a git sub command like status or commit
+type command func(r *Repo, c *gitCmd) int
+
+// commands maps git sub commands to their implementations
+var commands = map[string]command{
+	"add":       (*Repo).add,
+	"branch":    (*Repo).branch,
+	"checkout":  (*Repo).checkout,
+	"commit":    (*Repo).commit,
+	"diff":      (*Repo).diff,
+	"log":       (*Repo).log,
+	"push":      (*Repo).push,
+	"remote":    (*Repo).remote,
+	"rev-parse": (*Repo).revParse,
+	"status":    (*Repo).status,
+	"tag":       (*Repo).tag,
+}
+
+// status implements git status
+func (r *Repo) status(c *gitCmd) int {
+	porcelain, showBranch := false, false
+	for _, arg := range c.args {
+		switch arg {
+		case "--porcelain", "--porcelain=v1", "-s", "--short":
+			porcelain = true
+		case "-b", "--branch":
+			showBranch = true
+		default:
+			return c.fail(fatal("unsupported option '%s'", arg))
+		}
+	}
+
+	var lines []string
+	head := r.headTree()
+	seen := map[string]bool{}
+	for _, tree := range []map[string]string{head, r.index, r.worktree} {
+		for name := range tree {
+			seen[name] = true
+		}
+	}
+
+	for _, name := range sortedKeys(seen) {
+		headContent, inHead := head[name]
+		indexContent, inIndex := r.index[name]
+		workContent, inWork := r.worktree[name]
+
+		if !inHead && !inIndex {
+			lines = append(lines, "?? "+name)
+			continue
+		}
+
+		x := ' '
+		switch {
+		case inIndex && !inHead:
+			x = 'A'
+		case inHead && !inIndex:
+			x = 'D'
+		case headContent != indexContent:
+			x = 'M'
+		}
+
+		y := ' '
+		switch {
+		case inIndex && !inWork:
+			y = 'D'
+		case inIndex && workContent != indexContent:
+			y = 'M'
+		}
+
+		if x != ' ' || y != ' ' {
+			lines = append(lines, fmt.Sprintf("%c%c %s", x, y, name))
+		}
+		if !inIndex && inWork {
+			lines = append(lines, "?? "+name)
+		}
+	}
+
+	if !porcelain {
+		if r.head == "" {
+			fmt.Fprintf(c.stdout, "HEAD detached at %s\n", short(r.detached))
+		} else {
+			fmt.Fprintf(c.stdout, "On branch %s\n", r.head)
+		}
+		if len(lines) == 0 {
+			fmt.Fprintln(c.stdout, "nothing to commit, working tree clean")
+			return 0
+		}
+	}
+
+	if porcelain && showBranch {
+		if r.head == "" {
+			fmt.Fprintln(c.stdout, "## HEAD (no branch)")
+		} else {
+			fmt.Fprintf(c.stdout, "## %s\n", r.head)
+		}
+	}
+	for _, line := range lines {
+		fmt.Fprintln(c.stdout, line)
+	}
+
+	return 0
+}
+
+// add implements git add
+func (r *Repo) add(c *gitCmd) int {
+	var specs []string
+	for _, arg := range c.args {
+		switch arg {
+		case "-A", "--all":
+			specs = append(specs, ".")
+		case "--":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return c.fail(fatal("unsupported option '%s'", arg))
+			}
+			specs = append(specs, arg)
+		}
+	}
+
+	if len(specs) == 0 {
+		fmt.Fprintln(c.stdout, "Nothing specified, nothing added.")
+		return 0
+	}
+
+	for _, spec := range specs {
+		matched := false
+		for _, name := range sortedKeys(r.worktree) {
+			if matchPath(spec, name) {
+				r.index[name] = r.worktree[name]
+				matched = true
+			}
+		}
+		for _, name := range sortedKeys(r.index) {
+			if _, ok := r.worktree[name]; !ok && matchPath(spec, name) {
+				delete(r.index, name)
+				matched = true
+			}
+		}
+
+		if !matched {
+			return c.fail(fatal("pathspec '%s' did not match any files", spec))
+		}
+	}
+
+	return 0
+}
+
+// commit implements git commit
+func (r *Repo) commit(c *gitCmd) int {
+	var messages []string
+	all, allowEmpty, quiet := false, false, false
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch {
+		case arg == "-m" || arg == "--message":
+			if i+1 >= len(c.args) {
+				return c.fail(fatal("switch `m' requires a value"))
+			}
+			i++
+			messages = append(messages, c.args[i])
+		case strings.HasPrefix(arg, "--message="):
+			messages = append(messages, strings.TrimPrefix(arg, "--message="))
+		case strings.HasPrefix(arg, "-m"):
+			messages = append(messages, strings.TrimPrefix(arg, "-m"))
+		case arg == "-a" || arg == "--all":
+			all = true
+		case arg == "-am":
+			if i+1 >= len(c.args) {
+				return c.fail(fatal("switch `m' requires a value"))
+			}
+			i++
+			all = true
+			messages = append(messages, c.args[i])
+		case arg == "--allow-empty":
+			allowEmpty = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		default:
+			return c.fail(fatal("unsupported option '%s'", arg))
+		}
+	}
+
+	if all {
+		for name := range r.index {
+			if content, ok := r.worktree[name]; ok {
+				r.index[name] = content
+			} else {
+				delete(r.index, name)
+			}
+		}
+	}
+
+	if !allowEmpty && sameTree(r.index, r.headTree()) {
+		fmt.Fprintln(c.stdout, "nothing to commit, working tree clean")
+		return 1
+	}
+
+	if len(messages) == 0 {
+		fmt.Fprintln(c.stderr, "Aborting commit due to empty commit message.")
+		return 1
+	}
+
+	cmt := r.newCommit(strings.Join(messages, "\n\n"))
+	if quiet {
+		return 0
+	}
+
+	ref := r.head
+	if ref == "" {
+		ref = "detached HEAD"
+	}
+	fmt.Fprintf(c.stdout, "[%s %s] %s\n", ref, short(cmt.hash), cmt.subject())
+
+	return 0
+}
+
+// checkout implements git checkout
+func (r *Repo) checkout(c *gitCmd) int {
+	var newBranch, target string
+	var paths []string
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch {
+		case arg == "-b":
+			if i+1 >= len(c.args) {
+				return c.fail(fatal("switch `b' requires a value"))
+			}
+			i++
+			newBranch = c.args[i]
+		case arg == "--":
+			paths = append(paths, c.args[i+1:]...)
+			i = len(c.args)
+		case strings.HasPrefix(arg, "-"):
+			return c.fail(fatal("unsupported option '%s'", arg))
+		case target == "":
+			target = arg
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	// checkout -- <paths> restores files from the index
+	if newBranch == "" && target == "" && len(paths) > 0 {
+		for _, spec := range paths {
+			matched := false
+			for name, content := range r.index {
+				if matchPath(spec, name) {
+					r.worktree[name] = content
+					matched = true
+				}
+			}
+			if !matched {
+				fmt.Fprintf(c.stderr, "error: pathspec '%s' did not match any file(s) known to git\n", spec)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if newBranch != "" {
+		if _, ok := r.branches[newBranch]; ok {
+			return c.fail(fatal("a branch named '%s' already exists", newBranch))
+		}
+
+		hash := ""
+		if head := r.headCommit(); head != nil {
+			hash = head.hash
+		}
+		if target != "" {
+			var err error
+			hash, err = r.resolve(target)
+			if err != nil {
+				return c.fail(err)
+			}
+		}
+
+		if err := r.switchTree(hash); err != nil {
+			return c.fail(err)
+		}
+		if hash != "" {
+			r.branches[newBranch] = hash
+		}
+		r.head, r.detached = newBranch, ""
+		fmt.Fprintf(c.stderr, "Switched to a new branch '%s'\n", newBranch)
+		return 0
+	}
+
+	if target == "" {
+		return 0
+	}
+
+	if hash, ok := r.branches[target]; ok {
+		if r.head == target {
+			fmt.Fprintf(c.stderr, "Already on '%s'\n", target)
+			return 0
+		}
+		if err := r.switchTree(hash); err != nil {
+			return c.fail(err)
+		}
+		r.head, r.detached = target, ""
+		fmt.Fprintf(c.stderr, "Switched to branch '%s'\n", target)
+		return 0
+	}
+
+	hash, err := r.resolve(target)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "error: pathspec '%s' did not match any file(s) known to git\n", target)
+		return 1
+	}
+	if err := r.switchTree(hash); err != nil {
+		return c.fail(err)
+	}
+	r.head, r.detached = "", hash
+	fmt.Fprintf(c.stderr, "HEAD is now at %s %s\n", short(hash), r.commits[hash].subject())
+
+	return 0
+}
+
+// switchTree updates the index and working tree to match the given commit.
+// local changes are kept unless they would be overwritten by the switch
+func (r *Repo) switchTree(hash string) error {
+	from := r.headTree()
+	to := map[string]string{}
+	if c, ok := r.commits[hash]; ok {
+		to = c.tree
+	}
+
+	var conflicts []string
+	names := map[string]bool{}
+	for _, tree := range []map[string]string{from, to} {
+		for name := range tree {
+			names[name] = true
+		}
+	}
+	for name := range names {
+		fromContent, inFrom := from[name]
+		toContent, inTo := to[name]
+		if inFrom == inTo && fromContent == toContent {
+			continue
+		}
+
+		indexContent, inIndex := r.index[name]
+		workContent, inWork := r.worktree[name]
+		if inIndex != inFrom || indexContent != fromContent || inWork != inIndex || workContent != indexContent {
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &gitError{
+			msg: "error: Your local changes to the following files would be overwritten by checkout:\n\t" +
+				strings.Join(conflicts, "\n\t") +
+				"\nPlease commit your changes or stash them before you switch branches.\nAborting",
+			code: 1,
+		}
+	}
+
+	for name := range names {
+		if content, ok := to[name]; ok {
+			r.index[name] = content
+			r.worktree[name] = content
+		} else {
+			delete(r.index, name)
+			delete(r.worktree, name)
+		}
+	}
+
+	return nil
+}
+
+// branch implements git branch
+func (r *Repo) branch(c *gitCmd) int {
+	var names []string
+	del := false
+	for _, arg := range c.args {
+		switch arg {
+		case "--show-current":
+			if r.head != "" {
+				fmt.Fprintln(c.stdout, r.head)
+			}
+			return 0
+		case "-d", "-D", "--delete":
+			del = true
+		case "--list", "-l":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return c.fail(fatal("unsupported option '%s'", arg))
+			}
+			names = append(names, arg)
+		}
+	}
+
+	if del {
+		for _, name := range names {
+			if _, ok := r.branches[name]; !ok {
+				fmt.Fprintf(c.stderr, "error: branch '%s' not found.\n", name)
+				return 1
+			}
+			if name == r.head {
+				fmt.Fprintf(c.stderr, "error: Cannot delete branch '%s' checked out\n", name)
+				return 1
+			}
+			fmt.Fprintf(c.stdout, "Deleted branch %s (was %s).\n", name, short(r.branches[name]))
+			delete(r.branches, name)
+		}
+		return 0
+	}
+
+	switch len(names) {
+	case 0:
+		if r.head == "" {
+			fmt.Fprintf(c.stdout, "* (HEAD detached at %s)\n", short(r.detached))
+		}
+		for _, name := range sortedKeys(r.branches) {
+			prefix := "  "
+			if name == r.head {
+				prefix = "* "
+			}
+			fmt.Fprintln(c.stdout, prefix+name)
+		}
+		return 0
+	case 1, 2:
+		if _, ok := r.branches[names[0]]; ok {
+			return c.fail(fatal("a branch named '%s' already exists", names[0]))
+		}
+		start := "HEAD"
+		if len(names) == 2 {
+			start = names[1]
+		}
+		hash, err := r.resolve(start)
+		if err != nil {
+			return c.fail(fatal("not a valid object name: '%s'", start))
+		}
+		r.branches[names[0]] = hash
+		return 0
+	default:
+		return c.fail(fatal("too many arguments"))
+	}
+}
+
+// tag implements git tag
+func (r *Repo) tag(c *gitCmd) int {
+	var names []string
+	del, list := false, false
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch arg {
+		case "-d", "--delete":
+			del = true
+		case "-l", "--list":
+			list = true
+		case "-a", "--annotate":
+		case "-m", "--message":
+			// tag messages are accepted but not stored
+			i++
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return c.fail(fatal("unsupported option '%s'", arg))
+			}
+			names = append(names, arg)
+		}
+	}
+
+	if del {
+		for _, name := range names {
+			hash, ok := r.tags[name]
+			if !ok {
+				fmt.Fprintf(c.stderr, "error: tag '%s' not found.\n", name)
+				return 1
+			}
+			fmt.Fprintf(c.stdout, "Deleted tag '%s' (was %s)\n", name, short(hash))
+			delete(r.tags, name)
+		}
+		return 0
+	}
+
+	if list || len(names) == 0 {
+		for _, name := range sortedKeys(r.tags) {
+			if len(names) > 0 {
+				if ok, _ := path.Match(names[0], name); !ok {
+					continue
+				}
+			}
+			fmt.Fprintln(c.stdout, name)
+		}
+		return 0
+	}
+
+	if len(names) > 2 {
+		return c.fail(fatal("too many arguments"))
+	}
+	if _, ok := r.tags[names[0]]; ok {
+		return c.fail(fatal("tag '%s' already exists", names[0]))
+	}
+	rev := "HEAD"
+	if len(names) == 2 {
+		rev = names[1]
+	}
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return c.fail(fatal("Failed to resolve '%s' as a valid ref.", rev))
+	}
+	r.tags[names[0]] = hash
+
+	return 0
+}
+
+// revParse implements git rev-parse
+func (r *Repo) revParse(c *gitCmd) int {
+	abbrevRef, verify := false, false
+	shortLen := 0
+	var revs []string
+	for _, arg := range c.args {
+		switch {
+		case arg == "--abbrev-ref":
+			abbrevRef = true
+		case arg == "--verify":
+			verify = true
+		case arg == "-q" || arg == "--quiet":
+		case arg == "--short":
+			shortLen = 7
+		case strings.HasPrefix(arg, "--short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil {
+				return c.fail(fatal("invalid --short value '%s'", arg))
+			}
+			shortLen = n
+		case arg == "--is-inside-work-tree":
+			fmt.Fprintln(c.stdout, "true")
+		case arg == "--git-dir":
+			fmt.Fprintln(c.stdout, ".git")
+		case arg == "--show-toplevel":
+			dir := c.fc.Dir()
+			if dir == "" {
+				dir = "/"
+			}
+			fmt.Fprintln(c.stdout, dir)
+		case strings.HasPrefix(arg, "-"):
+			return c.fail(fatal("unsupported option '%s'", arg))
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	if verify && len(revs) != 1 {
+		return c.fail(fatal("Needed a single revision"))
+	}
+
+	for _, rev := range revs {
+		if abbrevRef {
+			switch {
+			case (rev == "HEAD" || rev == "@") && r.head != "":
+				fmt.Fprintln(c.stdout, r.head)
+				continue
+			case rev == "HEAD" || rev == "@":
+				fmt.Fprintln(c.stdout, "HEAD")
+				continue
+			}
+			if _, ok := r.branches[rev]; ok {
+				fmt.Fprintln(c.stdout, rev)
+				continue
+			}
+		}
+
+		hash, err := r.resolve(rev)
+		if err != nil {
+			if verify {
+				return c.fail(fatal("Needed a single revision"))
+			}
+			fmt.Fprintln(c.stdout, rev)
+			return c.fail(err)
+		}
+		if shortLen > 0 && shortLen < len(hash) {
+			hash = hash[:shortLen]
+		}
+		fmt.Fprintln(c.stdout, hash)
+	}
+
+	return 0
+}
+
+// log implements git log
+func (r *Repo) log(c *gitCmd) int {
+	format := ""
+	maxCount := -1
+	var revs []string
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch {
+		case arg == "--oneline":
+			format = "%h %s"
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--pretty=format:"):
+			format = strings.TrimPrefix(arg, "--pretty=format:")
+		case strings.HasPrefix(arg, "--pretty=tformat:"):
+			format = strings.TrimPrefix(arg, "--pretty=tformat:")
+		case arg == "--pretty=oneline":
+			format = "%H %s"
+		case arg == "-n":
+			if i+1 >= len(c.args) {
+				return c.fail(fatal("switch `n' requires a value"))
+			}
+			i++
+			n, err := strconv.Atoi(c.args[i])
+			if err != nil {
+				return c.fail(fatal("'%s': not an integer", c.args[i]))
+			}
+			maxCount = n
+		case strings.HasPrefix(arg, "--max-count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+			if err != nil {
+				return c.fail(fatal("'%s': not an integer", arg))
+			}
+			maxCount = n
+		case strings.HasPrefix(arg, "-n") || (len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9'):
+			n, err := strconv.Atoi(strings.TrimLeft(arg, "-n"))
+			if err != nil {
+				return c.fail(fatal("'%s': not an integer", arg))
+			}
+			maxCount = n
+		case arg == "--":
+			i = len(c.args)
+		case strings.HasPrefix(arg, "-"):
+			return c.fail(fatal("unsupported option '%s'", arg))
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	if r.headCommit() == nil && len(revs) == 0 {
+		return c.fail(fatal("your current branch '%s' does not have any commits yet", r.head))
+	}
+
+	include, exclude := "HEAD", ""
+	switch len(revs) {
+	case 0:
+	case 1:
+		include = revs[0]
+		if strings.Contains(revs[0], "...") {
+			return c.fail(fatal("fakegit does not support symmetric difference ranges like '%s'", revs[0]))
+		}
+		if from, to, ok := strings.Cut(revs[0], ".."); ok {
+			exclude, include = from, to
+			if include == "" {
+				include = "HEAD"
+			}
+		}
+	default:
+		return c.fail(fatal("fakegit only supports a single revision or range"))
+	}
+
+	hash, err := r.resolve(include)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	hidden := map[string]bool{}
+	if exclude != "" {
+		ex, err := r.resolve(exclude)
+		if err != nil {
+			return c.fail(err)
+		}
+		for ; ex != ""; ex = r.commits[ex].parent {
+			hidden[ex] = true
+		}
+	}
+
+	for n := 0; hash != "" && !hidden[hash] && n != maxCount; n++ {
+		cmt := r.commits[hash]
+		if format == "" {
+			if n > 0 {
+				fmt.Fprintln(c.stdout)
+			}
+			fmt.Fprintf(c.stdout, "commit %s\nAuthor: %s <%s>\nDate:   %s\n\n", cmt.hash, cmt.name, cmt.email, cmt.time.Format("Mon Jan 2 15:04:05 2006 -0700"))
+			for _, line := range strings.Split(cmt.message, "\n") {
+				fmt.Fprintf(c.stdout, "    %s\n", line)
+			}
+		} else {
+			fmt.Fprintln(c.stdout, formatCommit(format, cmt))
+		}
+		hash = cmt.parent
+	}
+
+	return 0
+}
+
+// formatCommit expands the git pretty format placeholders for the commit
+func formatCommit(format string, c *commit) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'H':
+			b.WriteString(c.hash)
+		case 'h':
+			b.WriteString(short(c.hash))
+		case 'P':
+			b.WriteString(c.parent)
+		case 'p':
+			b.WriteString(short(c.parent))
+		case 's':
+			b.WriteString(c.subject())
+		case 'b':
+			b.WriteString(c.body())
+		case 'B':
+			b.WriteString(c.message)
+		case 'n':
+			b.WriteByte('\n')
+		case '%':
+			b.WriteByte('%')
+		case 'a', 'c':
+			if i+1 >= len(format) {
+				b.WriteString(format[i-1:])
+				continue
+			}
+			i++
+			switch format[i] {
+			case 'n':
+				b.WriteString(c.name)
+			case 'e':
+				b.WriteString(c.email)
+			case 't':
+				b.WriteString(strconv.FormatInt(c.time.Unix(), 10))
+			case 'I':
+				b.WriteString(c.time.Format("2006-01-02T15:04:05-07:00"))
+			default:
+				b.WriteString(format[i-2 : i+1])
+			}
+		default:
+			b.WriteString(format[i-1 : i+1])
+		}
+	}
+	return b.String()
+}
+
+// diff implements git diff --name-only and git diff --name-status
+func (r *Repo) diff(c *gitCmd) int {
+	nameOnly, nameStatus, cached := false, false, false
+	var revs []string
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch {
+		case arg == "--name-only":
+			nameOnly = true
+		case arg == "--name-status":
+			nameStatus = true
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case arg == "--":
+			i = len(c.args)
+		case strings.HasPrefix(arg, "-"):
+			return c.fail(fatal("unsupported option '%s'", arg))
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	if !nameOnly && !nameStatus {
+		return c.fail(fatal("fakegit only supports diff with --name-only or --name-status"))
+	}
+
+	if len(revs) == 1 {
+		// either side of a range can be left out, it defaults to HEAD
+		if from, to, ok := strings.Cut(revs[0], "..."); ok {
+			from, to = orHead(from), orHead(to)
+			fromHash, err := r.resolve(from)
+			if err != nil {
+				return c.fail(err)
+			}
+			toHash, err := r.resolve(to)
+			if err != nil {
+				return c.fail(err)
+			}
+
+			// A...B is the changes on B since it split from A
+			base := r.mergeBase(fromHash, toHash)
+			if base == "" {
+				return c.fail(fatal("%s...%s: no merge base", from, to))
+			}
+			revs = []string{base, to}
+		} else if from, to, ok := strings.Cut(revs[0], ".."); ok {
+			revs = []string{orHead(from), orHead(to)}
+		}
+	}
+
+	var from, to map[string]string
+	switch {
+	case len(revs) == 0 && cached:
+		from, to = r.headTree(), r.index
+	case len(revs) == 0:
+		from = r.index
+		to = map[string]string{}
+		for name := range r.index {
+			if content, ok := r.worktree[name]; ok {
+				to[name] = content
+			}
+		}
+	case len(revs) <= 2:
+		trees := make([]map[string]string, len(revs))
+		for i, rev := range revs {
+			hash, err := r.resolve(rev)
+			if err != nil {
+				return c.fail(err)
+			}
+			trees[i] = r.commits[hash].tree
+		}
+		from = trees[0]
+		if len(trees) == 2 {
+			to = trees[1]
+		} else {
+			tracked := r.index
+			if cached {
+				to = tracked
+				break
+			}
+			to = map[string]string{}
+			for name := range tracked {
+				if content, ok := r.worktree[name]; ok {
+					to[name] = content
+				}
+			}
+		}
+	default:
+		return c.fail(fatal("too many revisions"))
+	}
+
+	names := map[string]bool{}
+	for _, tree := range []map[string]string{from, to} {
+		for name := range tree {
+			names[name] = true
+		}
+	}
+
+	for _, name := range sortedKeys(names) {
+		fromContent, inFrom := from[name]
+		toContent, inTo := to[name]
+
+		status := ""
+		switch {
+		case inFrom && !inTo:
+			status = "D"
+		case !inFrom && inTo:
+			status = "A"
+		case fromContent != toContent:
+			status = "M"
+		default:
+			continue
+		}
+
+		if nameStatus {
+			fmt.Fprintf(c.stdout, "%s\t%s\n", status, name)
+		} else {
+			fmt.Fprintln(c.stdout, name)
+		}
+	}
+
+	return 0
+}
+
+// remote implements git remote
+func (r *Repo) remote(c *gitCmd) int {
+	if len(c.args) == 0 || c.args[0] == "-v" || c.args[0] == "--verbose" {
+		for _, name := range sortedKeys(r.remotes) {
+			if len(c.args) == 0 {
+				fmt.Fprintln(c.stdout, name)
+				continue
+			}
+			fmt.Fprintf(c.stdout, "%s\t%s (fetch)\n%s\t%s (push)\n", name, r.remotes[name], name, r.remotes[name])
+		}
+		return 0
+	}
+
+	args := c.args[1:]
+	switch c.args[0] {
+	case "add":
+		if len(args) != 2 {
+			return c.fail(fatal("usage: git remote add <name> <url>"))
+		}
+		if _, ok := r.remotes[args[0]]; ok {
+			return c.fail(&gitError{msg: fmt.Sprintf("error: remote %s already exists.", args[0]), code: 3})
+		}
+		r.remotes[args[0]] = args[1]
+	case "remove", "rm":
+		if len(args) != 1 {
+			return c.fail(fatal("usage: git remote remove <name>"))
+		}
+		if _, ok := r.remotes[args[0]]; !ok {
+			return c.fail(&gitError{msg: fmt.Sprintf("error: No such remote: '%s'", args[0]), code: 2})
+		}
+		delete(r.remotes, args[0])
+		delete(r.pushed, args[0])
+	case "get-url":
+		if len(args) != 1 {
+			return c.fail(fatal("usage: git remote get-url <name>"))
+		}
+		url, ok := r.remotes[args[0]]
+		if !ok {
+			return c.fail(&gitError{msg: fmt.Sprintf("error: No such remote '%s'", args[0]), code: 2})
+		}
+		fmt.Fprintln(c.stdout, url)
+	case "set-url":
+		if len(args) != 2 {
+			return c.fail(fatal("usage: git remote set-url <name> <url>"))
+		}
+		if _, ok := r.remotes[args[0]]; !ok {
+			return c.fail(&gitError{msg: fmt.Sprintf("error: No such remote '%s'", args[0]), code: 2})
+		}
+		r.remotes[args[0]] = args[1]
+	default:
+		return c.fail(fatal("unsupported remote command '%s'", c.args[0]))
+	}
+
+	return 0
+}
+
+// push implements git push by recording the pushed refs on the remote
+func (r *Repo) push(c *gitCmd) int {
+	tags := false
+	var args []string
+	for _, arg := range c.args {
+		switch arg {
+		case "--tags":
+			tags = true
+		case "-u", "--set-upstream", "--force", "-f", "--atomic":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return c.fail(fatal("unsupported option '%s'", arg))
+			}
+			args = append(args, arg)
+		}
+	}
+
+	remote := "origin"
+	if len(args) > 0 {
+		remote, args = args[0], args[1:]
+	}
+	if _, ok := r.remotes[remote]; !ok {
+		return c.fail(fatal("'%s' does not appear to be a git repository", remote))
+	}
+
+	refs := map[string]string{}
+	if len(args) == 0 && !tags {
+		if r.head == "" {
+			return c.fail(fatal("You are not currently on a branch."))
+		}
+		args = []string{r.head}
+	}
+	for _, spec := range args {
+		src, dst, ok := strings.Cut(spec, ":")
+		if !ok {
+			dst = src
+		}
+		hash, err := r.resolve(src)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "error: src refspec %s does not match any\n", src)
+			return 1
+		}
+
+		switch _, isTag := r.tags[strings.TrimPrefix(dst, "refs/tags/")]; {
+		case strings.HasPrefix(dst, "refs/"):
+		case isTag:
+			dst = "refs/tags/" + dst
+		default:
+			dst = "refs/heads/" + dst
+		}
+		refs[dst] = hash
+	}
+	if tags {
+		for name, hash := range r.tags {
+			refs["refs/tags/"+name] = hash
+		}
+	}
+
+	if r.pushed[remote] == nil {
+		r.pushed[remote] = map[string]string{}
+	}
+	fmt.Fprintf(c.stderr, "To %s\n", r.remotes[remote])
+	for _, ref := range sortedKeys(refs) {
+		r.pushed[remote][ref] = refs[ref]
+		fmt.Fprintf(c.stderr, " * [new ref]         %s -> %s\n", ref, ref)
+	}
+
+	return 0
+}
+
+// sameTree reports whether two file trees contain the same files
+func sameTree(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, content := range a {
+		if other, ok := b[name]; !ok || other != content {
+			return false
+		}
+	}
+	return true
+}
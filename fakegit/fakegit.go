@@ -0,0 +1,465 @@
+// Package fakegit provides a puffin.CmdFunc that behaves like git.
+// Rather than returning canned output, it keeps an in memory repository
+// (working tree, index, commits, branches, tags and remotes) so the output
+// of every command stays consistent with the commands that came before it.
+package fakegit
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bjatkin/puffin"
+)
+
+// commit is a single commit in the repository
+type commit struct {
+	hash    string
+	parent  string
+	message string
+	name    string
+	email   string
+	time    time.Time
+	tree    map[string]string
+}
+
+// subject returns the first line of the commit message
+func (c *commit) subject() string {
+	subject, _, _ := strings.Cut(c.message, "\n")
+	return subject
+}
+
+// body returns everything after the first line of the commit message
+func (c *commit) body() string {
+	_, body, _ := strings.Cut(c.message, "\n")
+	return strings.TrimLeft(body, "\n")
+}
+
+// Repo is an in memory git repository. Its Func method returns a
+// puffin.CmdFunc which runs git commands against the repository.
+type Repo struct {
+	mu sync.Mutex
+
+	worktree map[string]string
+	index    map[string]string
+	commits  map[string]*commit
+	branches map[string]string
+	tags     map[string]string
+	remotes  map[string]string
+	pushed   map[string]map[string]string
+
+	// head is the name of the checked out branch, if head is empty
+	// the repository is in a detached HEAD state at detached
+	head     string
+	detached string
+
+	name  string
+	email string
+	clock time.Time
+}
+
+// Option can be used to configure a Repo
+type Option func(*Repo)
+
+// WithBranch sets the name of the initial branch, the default is main.
+// An empty name is ignored
+func WithBranch(name string) Option {
+	return func(r *Repo) {
+		if name != "" {
+			r.head = name
+		}
+	}
+}
+
+// WithAuthor sets the author used for all new commits
+func WithAuthor(name, email string) Option {
+	return func(r *Repo) {
+		r.name = name
+		r.email = email
+	}
+}
+
+// WithRemote adds a remote to the repository
+func WithRemote(name, url string) Option {
+	return func(r *Repo) {
+		r.remotes[name] = url
+	}
+}
+
+// WithFiles adds files to the working tree of the repository
+func WithFiles(files map[string]string) Option {
+	return func(r *Repo) {
+		for name, content := range files {
+			r.worktree[name] = content
+		}
+	}
+}
+
+// New creates a new, empty repository with no commits
+func New(opts ...Option) *Repo {
+	r := &Repo{
+		worktree: map[string]string{},
+		index:    map[string]string{},
+		commits:  map[string]*commit{},
+		branches: map[string]string{},
+		tags:     map[string]string{},
+		remotes:  map[string]string{},
+		pushed:   map[string]map[string]string{},
+		head:     "main",
+		name:     "Puffin",
+		email:    "puffin@example.com",
+		clock:    time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Func returns a puffin.CmdFunc that runs git commands against the repository
+func (r *Repo) Func() puffin.CmdFunc {
+	return r.Run
+}
+
+// Run runs the git command described by fc against the repository
+// and returns the exit code git would have returned
+func (r *Repo) Run(fc *puffin.FuncCmd) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stdout := fc.Stdout()
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	stderr := fc.Stderr()
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	args := globalOpts(fc.Args()[1:])
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: git <command> [<args>]")
+		return 1
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "git: '%s' is not a git command. See 'git --help'.\n", args[0])
+		return 1
+	}
+
+	return cmd(r, &gitCmd{fc: fc, args: args[1:], stdout: stdout, stderr: stderr})
+}
+
+// WriteFile creates or updates a file in the working tree
+func (r *Repo) WriteFile(name, content string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.worktree[name] = content
+}
+
+// RemoveFile removes a file from the working tree
+func (r *Repo) RemoveFile(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.worktree, name)
+}
+
+// ReadFile returns the content of a file in the working tree
+func (r *Repo) ReadFile(name string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	content, ok := r.worktree[name]
+	return content, ok
+}
+
+// Head returns the name of the checked out branch, or the checked out
+// commit hash if the repository is in a detached HEAD state
+func (r *Repo) Head() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.head == "" {
+		return r.detached
+	}
+	return r.head
+}
+
+// Resolve returns the commit hash of the given revision
+func (r *Repo) Resolve(rev string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolve(rev)
+}
+
+// Pushed returns the refs that have been pushed to the named remote
+// along with the commit hash each ref pointed to when it was pushed
+func (r *Repo) Pushed(remote string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pushed := map[string]string{}
+	for ref, hash := range r.pushed[remote] {
+		pushed[ref] = hash
+	}
+	return pushed
+}
+
+// globalOpts strips the options that can come before the git command
+func globalOpts(args []string) []string {
+	for len(args) > 0 {
+		switch {
+		case args[0] == "-C" || args[0] == "-c":
+			if len(args) < 2 {
+				return nil
+			}
+			args = args[2:]
+		case args[0] == "--no-pager" || strings.HasPrefix(args[0], "--git-dir="):
+			args = args[1:]
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+// headCommit returns the commit HEAD points to, or nil if the current branch has no commits
+func (r *Repo) headCommit() *commit {
+	if r.head == "" {
+		return r.commits[r.detached]
+	}
+	return r.commits[r.branches[r.head]]
+}
+
+// headTree returns the tree of the HEAD commit
+func (r *Repo) headTree() map[string]string {
+	if c := r.headCommit(); c != nil {
+		return c.tree
+	}
+	return map[string]string{}
+}
+
+// resolve converts a revision into a commit hash
+func (r *Repo) resolve(rev string) (string, error) {
+	base, steps := rev, 0
+	for {
+		switch {
+		case strings.HasSuffix(base, "^"):
+			base = strings.TrimSuffix(base, "^")
+			steps++
+			continue
+		case strings.Contains(base, "~"):
+			i := strings.LastIndex(base, "~")
+			n := 1
+			if i < len(base)-1 {
+				if _, err := fmt.Sscanf(base[i+1:], "%d", &n); err != nil {
+					return "", unknownRev(rev)
+				}
+			}
+			base = base[:i]
+			steps += n
+			continue
+		}
+		break
+	}
+
+	hash, ok := r.lookup(base)
+	if !ok {
+		return "", unknownRev(rev)
+	}
+
+	for i := 0; i < steps; i++ {
+		hash = r.commits[hash].parent
+		if hash == "" {
+			return "", unknownRev(rev)
+		}
+	}
+
+	return hash, nil
+}
+
+// mergeBase returns the best common ancestor of the commits a and b, or an empty string
+// if they don't have one. Commits have a single parent so it's the first ancestor of b
+// that's also an ancestor of a
+func (r *Repo) mergeBase(a, b string) string {
+	ancestors := map[string]bool{}
+	for ; a != ""; a = r.commits[a].parent {
+		ancestors[a] = true
+	}
+	for ; b != ""; b = r.commits[b].parent {
+		if ancestors[b] {
+			return b
+		}
+	}
+	return ""
+}
+
+// lookup finds the commit for a name without any ancestry suffix
+func (r *Repo) lookup(name string) (string, bool) {
+	if name == "HEAD" || name == "@" {
+		c := r.headCommit()
+		if c == nil {
+			return "", false
+		}
+		return c.hash, true
+	}
+
+	name = strings.TrimPrefix(name, "refs/")
+	if hash, ok := r.branches[strings.TrimPrefix(name, "heads/")]; ok {
+		return hash, true
+	}
+	if hash, ok := r.tags[strings.TrimPrefix(name, "tags/")]; ok {
+		return hash, true
+	}
+	if remote, branch, ok := strings.Cut(strings.TrimPrefix(name, "remotes/"), "/"); ok {
+		if hash, ok := r.pushed[remote]["refs/heads/"+branch]; ok {
+			return hash, true
+		}
+	}
+
+	if len(name) < 4 {
+		return "", false
+	}
+
+	var found string
+	for hash := range r.commits {
+		if strings.HasPrefix(hash, name) {
+			if found != "" {
+				// the prefix is ambiguous
+				return "", false
+			}
+			found = hash
+		}
+	}
+
+	return found, found != ""
+}
+
+// newCommit creates a commit from the current index with HEAD as its parent
+func (r *Repo) newCommit(message string) *commit {
+	r.clock = r.clock.Add(time.Minute)
+
+	c := &commit{
+		message: message,
+		name:    r.name,
+		email:   r.email,
+		time:    r.clock,
+		tree:    copyTree(r.index),
+	}
+	if parent := r.headCommit(); parent != nil {
+		c.parent = parent.hash
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "parent %s\nauthor %s <%s> %d\n\n%s\n", c.parent, c.name, c.email, c.time.Unix(), c.message)
+	for _, name := range sortedKeys(c.tree) {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, c.tree[name])
+	}
+	c.hash = hex.EncodeToString(h.Sum(nil))
+
+	r.commits[c.hash] = c
+	if r.head == "" {
+		r.detached = c.hash
+	} else {
+		r.branches[r.head] = c.hash
+	}
+
+	return c
+}
+
+// gitError is an error reported by a git command
+type gitError struct {
+	msg  string
+	code int
+}
+
+func (e *gitError) Error() string {
+	return e.msg
+}
+
+// fatal creates a new git error with the same exit code git uses for fatal errors
+func fatal(format string, a ...any) error {
+	return &gitError{msg: "fatal: " + fmt.Sprintf(format, a...), code: 128}
+}
+
+// unknownRev is the error git reports when it can not resolve a revision
+func unknownRev(rev string) error {
+	return fatal("ambiguous argument '%s': unknown revision or path not in the working tree.", rev)
+}
+
+// gitCmd holds the state for a single git command
+type gitCmd struct {
+	fc     *puffin.FuncCmd
+	args   []string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// fail reports the error on stderr and returns the exit code for it
+func (c *gitCmd) fail(err error) int {
+	fmt.Fprintln(c.stderr, err)
+	if gErr, ok := err.(*gitError); ok {
+		return gErr.code
+	}
+	return 1
+}
+
+// copyTree returns a copy of a file tree
+func copyTree(tree map[string]string) map[string]string {
+	cp := make(map[string]string, len(tree))
+	for name, content := range tree {
+		cp[name] = content
+	}
+	return cp
+}
+
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matchPath reports whether a file is matched by a git pathspec
+func matchPath(spec, file string) bool {
+	spec = strings.TrimSuffix(strings.TrimPrefix(spec, "./"), "/")
+	if spec == "." || spec == "" || spec == file {
+		return true
+	}
+	if strings.HasPrefix(file, spec+"/") {
+		return true
+	}
+	ok, _ := path.Match(spec, file)
+	return ok
+}
+
+// short returns the abbreviated form of a commit hash
+func short(hash string) string {
+	if len(hash) < 7 {
+		return hash
+	}
+	return hash[:7]
+}
+
+// orHead returns rev, or HEAD if rev is empty like a side that's left out of a range
+func orHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
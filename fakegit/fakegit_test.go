@@ -0,0 +1,282 @@
+package fakegit
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bjatkin/puffin"
+)
+
+// step is a single git command run against a test repo
+type step struct {
+	args     string
+	want     string
+	wantCode int
+}
+
+// runSteps runs each step against the repo and checks its stdout and exit code
+func runSteps(t *testing.T, repo *Repo, steps []step) {
+	t.Helper()
+
+	exec := puffin.NewFuncExec(
+		puffin.WithFuncMap(map[string]puffin.CmdFunc{
+			"git": repo.Func(),
+		}),
+	)
+
+	for _, s := range steps {
+		stdout, stderr := &strings.Builder{}, &strings.Builder{}
+		cmd := exec.Command("git", strings.Fields(s.args)...)
+		cmd.SetStdout(stdout)
+		cmd.SetStderr(stderr)
+
+		err := cmd.Run()
+		if (err != nil) != (s.wantCode != 0) {
+			t.Fatalf("git %s error = %v, want code %d, stderr %s", s.args, err, s.wantCode, stderr)
+		}
+		if s.wantCode != 0 && err.Error() != "exit status "+strconv.Itoa(s.wantCode) {
+			t.Fatalf("git %s error = %v, want code %d", s.args, err, s.wantCode)
+		}
+		if stdout.String() != s.want {
+			t.Fatalf("git %s = %q, want %q", s.args, stdout.String(), s.want)
+		}
+	}
+}
+
+func TestRepo_Run(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		steps []step
+	}{
+		{
+			"status of new files",
+			[]Option{WithFiles(map[string]string{"README.md": "hello"})},
+			[]step{
+				{"status --porcelain", "?? README.md\n", 0},
+				{"add README.md", "", 0},
+				{"status --porcelain", "A  README.md\n", 0},
+				{"commit -q -m initial", "", 0},
+				{"status --porcelain", "", 0},
+			},
+		},
+		{
+			"unknown command",
+			nil,
+			[]step{
+				{"frobnicate", "", 1},
+			},
+		},
+		{
+			"log of an empty repo",
+			nil,
+			[]step{
+				{"log --format=%s", "", 128},
+			},
+		},
+		{
+			"nothing to commit",
+			nil,
+			[]step{
+				{"commit -q -m empty", "nothing to commit, working tree clean\n", 1},
+				{"commit -q --allow-empty -m empty", "", 0},
+				{"log --format=%s", "empty\n", 0},
+			},
+		},
+		{
+			"branches",
+			[]Option{WithBranch("trunk"), WithFiles(map[string]string{"a.txt": "a"})},
+			[]step{
+				{"add -A", "", 0},
+				{"commit -q -m first", "", 0},
+				{"branch feature", "", 0},
+				{"branch", "  feature\n* trunk\n", 0},
+				{"checkout feature", "", 0},
+				{"rev-parse --abbrev-ref HEAD", "feature\n", 0},
+				{"branch --show-current", "feature\n", 0},
+				{"branch -d feature", "", 1},
+				{"checkout -b other", "", 0},
+				{"branch", "  feature\n* other\n  trunk\n", 0},
+			},
+		},
+		{
+			"tags",
+			[]Option{WithFiles(map[string]string{"a.txt": "a"})},
+			[]step{
+				{"add .", "", 0},
+				{"commit -q -m first", "", 0},
+				{"tag v1.0.0", "", 0},
+				{"tag v1.0.0", "", 128},
+				{"tag -a v1.1.0 -m release", "", 0},
+				{"tag", "v1.0.0\nv1.1.0\n", 0},
+				{"tag -l v1.1*", "v1.1.0\n", 0},
+				{"log --format=%s v1.0.0", "first\n", 0},
+			},
+		},
+		{
+			"unknown revision",
+			nil,
+			[]step{
+				{"rev-parse --verify missing", "", 128},
+			},
+		},
+		{
+			"remotes",
+			[]Option{WithRemote("origin", "git@example.com:puffin.git")},
+			[]step{
+				{"remote", "origin\n", 0},
+				{"remote add upstream https://example.com/puffin.git", "", 0},
+				{"remote -v", "origin\tgit@example.com:puffin.git (fetch)\norigin\tgit@example.com:puffin.git (push)\nupstream\thttps://example.com/puffin.git (fetch)\nupstream\thttps://example.com/puffin.git (push)\n", 0},
+				{"remote get-url upstream", "https://example.com/puffin.git\n", 0},
+				{"remote get-url missing", "", 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runSteps(t, New(tt.opts...), tt.steps)
+		})
+	}
+}
+
+func TestRepo_consistency(t *testing.T) {
+	repo := New(
+		WithFiles(map[string]string{"main.go": "package main", "go.mod": "module puffin"}),
+		WithRemote("origin", "git@example.com:puffin.git"),
+	)
+	runSteps(t, repo, []step{
+		{"add -A", "", 0},
+		{"commit -q -m initial", "", 0},
+	})
+
+	first, err := repo.Resolve("HEAD")
+	if err != nil {
+		t.Fatalf("Repo.Resolve() error = %v", err)
+	}
+
+	repo.WriteFile("main.go", "package main\n\nfunc main() {}")
+	repo.WriteFile("new.go", "package main")
+	repo.RemoveFile("go.mod")
+	runSteps(t, repo, []step{
+		{"status --porcelain", " D go.mod\n M main.go\n?? new.go\n", 0},
+		{"diff --name-only", "go.mod\nmain.go\n", 0},
+		{"add main.go new.go", "", 0},
+		{"status --porcelain", " D go.mod\nM  main.go\nA  new.go\n", 0},
+		{"diff --name-only --cached", "main.go\nnew.go\n", 0},
+		{"commit -q -a -m second", "", 0},
+		{"log --format=%s", "second\ninitial\n", 0},
+		{"rev-parse HEAD~1", first + "\n", 0},
+		{"rev-parse --short HEAD^", first[:7] + "\n", 0},
+		{"diff --name-status HEAD~1 HEAD", "D\tgo.mod\nM\tmain.go\nA\tnew.go\n", 0},
+		{"diff --name-status HEAD~1..", "D\tgo.mod\nM\tmain.go\nA\tnew.go\n", 0},
+		{"diff --name-status ..HEAD~1", "A\tgo.mod\nM\tmain.go\nD\tnew.go\n", 0},
+		{"diff --name-only HEAD..", "", 0},
+		{"log --format=%h -n 1 " + first, first[:7] + "\n", 0},
+		{"checkout " + first[:8], "", 0},
+		{"branch", "* (HEAD detached at " + first[:7] + ")\n  main\n", 0},
+		{"status --porcelain -b", "## HEAD (no branch)\n", 0},
+		{"status", "HEAD detached at " + first[:7] + "\nnothing to commit, working tree clean\n", 0},
+		{"checkout main", "", 0},
+		{"tag v1.0.0", "", 0},
+		{"push origin main v1.0.0", "", 0},
+	})
+
+	if content, ok := repo.ReadFile("new.go"); !ok || content != "package main" {
+		t.Errorf("Repo.ReadFile() = %q, %v, want %q, true", content, ok, "package main")
+	}
+
+	head, _ := repo.Resolve("HEAD")
+	pushed := repo.Pushed("origin")
+	if pushed["refs/heads/main"] != head || pushed["refs/tags/v1.0.0"] != head {
+		t.Errorf("Repo.Pushed() = %v, want main and v1.0.0 at %s", pushed, head)
+	}
+
+	runSteps(t, repo, []step{
+		{"log --format=%s origin/main~1..origin/main", "second\n", 0},
+	})
+}
+
+func TestRepo_ranges(t *testing.T) {
+	// an empty branch name is ignored
+	repo := New(WithBranch(""), WithFiles(map[string]string{"a.txt": "a"}))
+	runSteps(t, repo, []step{
+		{"branch --show-current", "main\n", 0},
+		{"add -A", "", 0},
+		{"commit -q -m base", "", 0},
+		{"checkout -b feature", "", 0},
+	})
+
+	repo.WriteFile("b.txt", "b")
+	runSteps(t, repo, []step{
+		{"add b.txt", "", 0},
+		{"commit -q -m feature", "", 0},
+		{"checkout main", "", 0},
+	})
+
+	repo.WriteFile("a.txt", "changed")
+	runSteps(t, repo, []step{
+		{"commit -q -a -m change", "", 0},
+		{"diff --name-only main..feature", "a.txt\nb.txt\n", 0},
+		{"diff --name-only main...feature", "b.txt\n", 0},
+		{"diff --name-only feature...", "a.txt\n", 0},
+		{"diff --name-only main...missing", "", 128},
+		{"log --format=%s main..feature", "feature\n", 0},
+		{"log --format=%s main...feature", "", 128},
+	})
+}
+
+func TestRepo_checkoutConflict(t *testing.T) {
+	repo := New(WithFiles(map[string]string{"a.txt": "one"}))
+	runSteps(t, repo, []step{
+		{"add -A", "", 0},
+		{"commit -q -m first", "", 0},
+		{"checkout -b feature", "", 0},
+	})
+
+	repo.WriteFile("a.txt", "two")
+	runSteps(t, repo, []step{
+		{"commit -q -am second", "", 0},
+	})
+
+	repo.WriteFile("a.txt", "local change")
+	runSteps(t, repo, []step{
+		{"checkout main", "", 1},
+		{"checkout -- a.txt", "", 0},
+		{"checkout main", "", 0},
+	})
+
+	if content, _ := repo.ReadFile("a.txt"); content != "one" {
+		t.Errorf("Repo.ReadFile() = %q, want %q", content, "one")
+	}
+	if head := repo.Head(); head != "main" {
+		t.Errorf("Repo.Head() = %q, want %q", head, "main")
+	}
+}
+
+func Test_formatCommit(t *testing.T) {
+	c := &commit{
+		hash:    "0123456789abcdef",
+		parent:  "fedcba9876543210",
+		message: "subject\n\nbody",
+		name:    "Puffin",
+		email:   "puffin@example.com",
+	}
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"hashes", "%H %h %P %p", "0123456789abcdef 0123456 fedcba9876543210 fedcba9"},
+		{"message", "%s|%b", "subject|body"},
+		{"author", "%an <%ae>", "Puffin <puffin@example.com>"},
+		{"escapes", "100%% %x", "100% %x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCommit(tt.format, c); got != tt.want {
+				t.Errorf("formatCommit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,642 @@
+package puffin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/bjatkin/puffin/internal/txtar"
+	"github.com/bjatkin/puffin/internal/words"
+	"github.com/bjatkin/puffin/internal/yaml"
+)
+
+// Fake is a declarative description of how a fake command should behave.
+// Fakes can be written in go or loaded from txtar, yaml or json files with LoadFakes
+type Fake struct {
+	// Command is the name or path of the command being faked
+	Command string
+
+	// Args are patterns that are matched against the command arguments.
+	// Each pattern is matched against a single argument using path.Match,
+	// a final pattern of "..." matches any remaining arguments
+	Args []string
+
+	// Env lists environment variables that must be set for the fake to match.
+	// Entries are either NAME, or NAME=pattern to also match the value
+	Env []string
+
+	// Stdin, if not nil, must match the commands standard input exactly. Standard input is
+	// only read if it's checked, or if the Stdout or Stderr templates refer to .Stdin
+	Stdin *string
+
+	// Stdout and Stderr are text/template templates that are written to the
+	// commands standard output and standard error
+	Stdout string
+	Stderr string
+
+	// ExitCode is the exit code the fake command returns
+	ExitCode int
+
+	// Delay is how long the fake command waits before it writes any output
+	Delay time.Duration
+
+	stdout *template.Template
+	stderr *template.Template
+
+	// readsStdin is set if the output templates may use the standard input
+	readsStdin bool
+}
+
+// FakeData is the data available to the Stdout and Stderr templates of a Fake.
+// In addition to the standard template functions, join can be used to join a list
+// of arguments with spaces, e.g. {{join .Args}}
+type FakeData struct {
+	// Args are the arguments the command was run with, not including the command name
+	Args []string
+
+	// Match holds the arguments that were matched by a wildcard pattern, in order
+	Match []string
+
+	// Env is the environment of the command
+	Env map[string]string
+
+	// Stdin is everything that was read from the commands standard input
+	Stdin string
+}
+
+// fakeTemplateFuncs are the extra functions available in the Stdout and Stderr templates of a Fake
+var fakeTemplateFuncs = template.FuncMap{
+	// join joins a list of arguments with spaces
+	"join": func(args []string) string {
+		return strings.Join(args, " ")
+	},
+}
+
+// compile parses the output templates of the fake
+func (f *Fake) compile() error {
+	var err error
+	f.stdout, err = template.New("stdout").Funcs(fakeTemplateFuncs).Parse(f.Stdout)
+	if err != nil {
+		return fmt.Errorf("fake %s: %w", f.Command, err)
+	}
+
+	f.stderr, err = template.New("stderr").Funcs(fakeTemplateFuncs).Parse(f.Stderr)
+	if err != nil {
+		return fmt.Errorf("fake %s: %w", f.Command, err)
+	}
+
+	f.readsStdin = usesStdin(f.stdout) || usesStdin(f.stderr)
+	return nil
+}
+
+// usesStdin checks if any of the templates associated with t refer to the Stdin field of FakeData,
+// e.g. {{.Stdin}}, {{$.Stdin}} or {{$data.Stdin}}
+func usesStdin(t *template.Template) bool {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil && nodeUsesStdin(tmpl.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeUsesStdin walks the template node looking for a reference to the Stdin field
+func nodeUsesStdin(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if nodeUsesStdin(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeUsesStdin(n.Pipe)
+	case *parse.IfNode:
+		return nodeUsesStdin(&n.BranchNode)
+	case *parse.RangeNode:
+		return nodeUsesStdin(&n.BranchNode)
+	case *parse.WithNode:
+		return nodeUsesStdin(&n.BranchNode)
+	case *parse.BranchNode:
+		return nodeUsesStdin(n.Pipe) || nodeUsesStdin(n.List) || nodeUsesStdin(n.ElseList)
+	case *parse.TemplateNode:
+		return nodeUsesStdin(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if nodeUsesStdin(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if nodeUsesStdin(arg) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == "Stdin"
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[1] == "Stdin"
+	case *parse.ChainNode:
+		return nodeUsesStdin(n.Node) || (len(n.Field) > 0 && n.Field[0] == "Stdin")
+	}
+	return false
+}
+
+// match checks if the fake matches the args, env and stdin, returning the wildcard matches if it does.
+// stdin is only called if the fake checks the standard input
+func (f *Fake) match(args []string, env map[string]string, stdin func() string) ([]string, bool) {
+	matched, ok := matchArgs(f.Args, args)
+	if !ok || !matchEnv(f.Env, env) {
+		return nil, false
+	}
+
+	if f.Stdin != nil && *f.Stdin != stdin() {
+		return nil, false
+	}
+
+	return matched, true
+}
+
+// matchArgs matches each argument against a path.Match pattern, a final pattern of "..."
+// matches any remaining arguments. It returns the arguments that were matched by wildcards
+func matchArgs(patterns, args []string) ([]string, bool) {
+	var matched []string
+	for i, pattern := range patterns {
+		if pattern == "..." && i == len(patterns)-1 && i <= len(args) {
+			matched = append(matched, args[i:]...)
+			args = args[:i]
+			break
+		}
+		if i >= len(args) {
+			return nil, false
+		}
+
+		ok, err := path.Match(pattern, args[i])
+		if err != nil || !ok {
+			return nil, false
+		}
+		if isWildcard(pattern) {
+			matched = append(matched, args[i])
+		}
+	}
+	if len(args) > len(patterns) {
+		return nil, false
+	}
+
+	return matched, true
+}
+
+// isWildcard checks if the path.Match pattern has any unescaped * ? or [ characters
+func isWildcard(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// matchEnv checks that every env var in patterns is set. Patterns are either NAME,
+// or NAME=pattern to also match the value with path.Match
+func matchEnv(patterns []string, env map[string]string) bool {
+	for _, e := range patterns {
+		name, pattern, hasValue := strings.Cut(e, "=")
+		val, ok := env[name]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, val); hasValue && !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FakeFuncs converts a list of fakes into a func map that can be used with WithFuncMap.
+// When a command is run, the first fake for that command that matches its arguments, env,
+// and stdin is used. If no fake matches, the command fails with exit code 127
+func FakeFuncs(fakes []Fake) (map[string]CmdFunc, error) {
+	byCommand := map[string][]Fake{}
+	var order []string
+	for _, f := range fakes {
+		if f.Command == "" {
+			return nil, fmt.Errorf("fake is missing a command")
+		}
+		if err := f.compile(); err != nil {
+			return nil, err
+		}
+		if _, ok := byCommand[f.Command]; !ok {
+			order = append(order, f.Command)
+		}
+		byCommand[f.Command] = append(byCommand[f.Command], f)
+	}
+
+	funcs := map[string]CmdFunc{}
+	for _, name := range order {
+		funcs[name] = fakeFunc(byCommand[name])
+	}
+
+	return funcs, nil
+}
+
+// fakeFunc creates a CmdFunc that runs the first matching fake
+func fakeFunc(fakes []Fake) CmdFunc {
+	return func(fc *FuncCmd) int {
+		stdout, stderr := orDiscard(fc.Stdout()), orDiscard(fc.Stderr())
+
+		// standard input is read at most once, and only if a fake needs it, so fakes of
+		// commands that don't read it don't block on an open pipe
+		var stdin *string
+		readStdin := func() string {
+			if stdin == nil {
+				var in []byte
+				if fc.Stdin() != nil {
+					in, _ = io.ReadAll(fc.Stdin())
+				}
+				s := string(in)
+				stdin = &s
+			}
+			return *stdin
+		}
+
+		env := map[string]string{}
+		for _, e := range fc.Environ() {
+			name, val, _ := strings.Cut(e, "=")
+			env[name] = val
+		}
+
+		args := fc.Args()[1:]
+		for _, f := range fakes {
+			matched, ok := f.match(args, env, readStdin)
+			if !ok {
+				continue
+			}
+
+			if f.Delay > 0 {
+				select {
+				case <-time.After(f.Delay):
+				case <-fc.Context().Done():
+					return -1
+				}
+			}
+
+			data := FakeData{Args: args, Match: matched, Env: env}
+			if f.readsStdin {
+				data.Stdin = readStdin()
+			}
+			if err := f.stdout.Execute(stdout, data); err != nil {
+				fmt.Fprintf(stderr, "puffin: fake %s: %s\n", f.Command, err)
+				return 1
+			}
+			if err := f.stderr.Execute(stderr, data); err != nil {
+				fmt.Fprintf(stderr, "puffin: fake %s: %s\n", f.Command, err)
+				return 1
+			}
+
+			return f.ExitCode
+		}
+
+		fmt.Fprintf(stderr, "puffin: no fake matches %q\n", strings.Join(fc.Args(), " "))
+		return 127
+	}
+}
+
+// WithFakes adds the fakes to the func map used by all the commands created by this Exec.
+// It should come after WithFuncMap if both are used. If any of the fakes are invalid every
+// command created by the Exec fails to start with the error, which is also returned by Cmd.Err
+func WithFakes(fakes []Fake) FuncExecOption {
+	funcs, err := FakeFuncs(fakes)
+	return func(fExec *FuncExec) {
+		if err != nil {
+			fExec.err = err
+			return
+		}
+
+		funcMap := map[string]CmdFunc{}
+		for name, fn := range fExec.funcMap {
+			funcMap[name] = fn
+		}
+		for name, fn := range funcs {
+			funcMap[name] = fn
+		}
+		fExec.funcMap = funcMap
+	}
+}
+
+// LoadFakes loads fakes from txtar, yaml or json files. Files ending in .yaml or .yml are
+// parsed with ParseFakesYAML, files ending in .json with ParseFakesJSON and all others with ParseFakes
+func LoadFakes(files ...string) ([]Fake, error) {
+	var fakes []Fake
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		parse := ParseFakes
+		switch filepath.Ext(file) {
+		case ".yaml", ".yml":
+			parse = ParseFakesYAML
+		case ".json":
+			parse = ParseFakesJSON
+		}
+
+		parsed, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		fakes = append(fakes, parsed...)
+	}
+
+	return fakes, nil
+}
+
+// ParseFakes parses fakes from a txtar archive. The archive comment is ignored and each file
+// in the archive is a single fake, the file name is the command followed by its argument
+// patterns and the file content is parsed by ParseFake. For example
+//
+//	-- git rev-parse --abbrev-ref * --
+//	env GIT_DIR
+//	stdout {{index .Match 0}}
+//	-- git push ... --
+//	delay 50ms
+//	stderr fatal: could not read from remote repository
+//	exit 128
+func ParseFakes(data []byte) ([]Fake, error) {
+	var fakes []Fake
+	for _, file := range txtar.Parse(data).Files {
+		fake, err := ParseFake(file.Name, file.Data)
+		if err != nil {
+			return nil, err
+		}
+		fakes = append(fakes, fake)
+	}
+
+	return fakes, nil
+}
+
+// ParseFake parses a single fake. The pattern is the command followed by its argument patterns
+// and the body holds one directive per line. Blank lines and lines starting with # are ignored.
+//
+//	stdout <text>   appends a line of text to the standard output template
+//	stderr <text>   appends a line of text to the standard error template
+//	stdin <text>    appends a line of text to the expected standard input
+//	env <NAME[=pattern]>  requires an environment variable to be set
+//	exit <code>     sets the exit code
+//	delay <duration>      sets the delay before any output is written
+func ParseFake(pattern string, body []byte) (Fake, error) {
+	fields, err := words.Split(pattern)
+	if err != nil {
+		return Fake{}, fmt.Errorf("fake %q: %w", pattern, err)
+	}
+	if len(fields) == 0 {
+		return Fake{}, fmt.Errorf("fake is missing a command")
+	}
+
+	fake := Fake{Command: fields[0], Args: fields[1:]}
+	var stdout, stderr, stdin strings.Builder
+	hasStdin := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		directive, value, _ := strings.Cut(line, " ")
+		switch directive {
+		case "stdout":
+			stdout.WriteString(value + "\n")
+		case "stderr":
+			stderr.WriteString(value + "\n")
+		case "stdin":
+			stdin.WriteString(value + "\n")
+			hasStdin = true
+		case "env":
+			fake.Env = append(fake.Env, value)
+		case "exit":
+			fake.ExitCode, err = strconv.Atoi(value)
+		case "delay":
+			fake.Delay, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown directive %q", directive)
+		}
+		if err != nil {
+			return Fake{}, fmt.Errorf("fake %q line %d: %w", pattern, n, err)
+		}
+	}
+
+	fake.Stdout = stdout.String()
+	fake.Stderr = stderr.String()
+	if hasStdin {
+		in := stdin.String()
+		fake.Stdin = &in
+	}
+
+	if err := fake.compile(); err != nil {
+		return Fake{}, err
+	}
+
+	return fake, nil
+}
+
+// jsonFake is the json representation of a Fake
+type jsonFake struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	Env      []string `json:"env"`
+	Stdin    *string  `json:"stdin"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr"`
+	ExitCode int      `json:"exit"`
+	Delay    string   `json:"delay"`
+}
+
+// ParseFakesJSON parses fakes from a json array of objects. For example
+//
+//	[
+//	  {"command": "git", "args": ["rev-parse", "*"], "stdout": "{{index .Match 0}}\n"},
+//	  {"command": "git", "args": ["push", "..."], "exit": 128, "delay": "50ms"}
+//	]
+func ParseFakesJSON(data []byte) ([]Fake, error) {
+	var decoded []jsonFake
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	fakes := make([]Fake, 0, len(decoded))
+	for _, d := range decoded {
+		fake := Fake{
+			Command:  d.Command,
+			Args:     d.Args,
+			Env:      d.Env,
+			Stdin:    d.Stdin,
+			Stdout:   d.Stdout,
+			Stderr:   d.Stderr,
+			ExitCode: d.ExitCode,
+		}
+		if d.Delay != "" {
+			var err error
+			fake.Delay, err = time.ParseDuration(d.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("fake %s: %w", d.Command, err)
+			}
+		}
+		if err := fake.compile(); err != nil {
+			return nil, err
+		}
+		fakes = append(fakes, fake)
+	}
+
+	return fakes, nil
+}
+
+// ParseFakesYAML parses fakes from a yaml sequence of mappings, the keys are the same as
+// the ones used by ParseFakesJSON. Only a subset of yaml is supported, anchors, aliases, tags,
+// flow mappings and folded scalars can not be used. For example
+//
+//	# testdata/fakes.yaml
+//	- command: git
+//	  args: [rev-parse, "*"]
+//	  stdout: |
+//	    {{index .Match 0}}
+//	- command: git
+//	  args: [push, ...]
+//	  exit: 128
+//	  delay: 50ms
+func ParseFakesYAML(data []byte) ([]Fake, error) {
+	doc, err := yaml.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	list, ok := doc.([]any)
+	if !ok {
+		return nil, fmt.Errorf("fakes must be a yaml sequence")
+	}
+
+	fakes := make([]Fake, 0, len(list))
+	for i, item := range list {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("fake %d is not a yaml mapping", i+1)
+		}
+
+		fake, err := yamlFake(fields)
+		if err != nil {
+			return nil, fmt.Errorf("fake %d: %w", i+1, err)
+		}
+		if err := fake.compile(); err != nil {
+			return nil, err
+		}
+		fakes = append(fakes, fake)
+	}
+
+	return fakes, nil
+}
+
+// yamlFake converts the fields of a yaml mapping into a Fake
+func yamlFake(fields map[string]any) (Fake, error) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fake Fake
+	for _, key := range keys {
+		var value string
+		var err error
+		switch key {
+		case "args":
+			fake.Args, err = yamlStrings(fields[key])
+		case "env":
+			fake.Env, err = yamlStrings(fields[key])
+		default:
+			value, err = yamlString(fields[key])
+		}
+		if err != nil {
+			return Fake{}, fmt.Errorf("%s: %w", key, err)
+		}
+
+		switch key {
+		case "args", "env":
+		case "command":
+			fake.Command = value
+		case "stdin":
+			fake.Stdin = &value
+		case "stdout":
+			fake.Stdout = value
+		case "stderr":
+			fake.Stderr = value
+		case "exit":
+			fake.ExitCode, err = strconv.Atoi(value)
+		case "delay":
+			fake.Delay, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return Fake{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return fake, nil
+}
+
+// yamlString converts a yaml scalar into a string, an empty value is an empty string
+func yamlString(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("expected a single value")
+}
+
+// yamlStrings converts a yaml sequence of scalars into a list of strings
+func yamlStrings(v any) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		s, err := yamlString(item)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
@@ -0,0 +1,455 @@
+package puffin
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFakes(t *testing.T) {
+	fakes, err := LoadFakes("testdata/fakes.txtar", "testdata/fakes.json", "testdata/fakes.yaml")
+	if err != nil {
+		t.Fatalf("LoadFakes() error = %v", err)
+	}
+
+	type args struct {
+		name  string
+		arg   []string
+		env   map[string]string
+		stdin string
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantStdout string
+		wantStderr string
+		wantErr    string
+	}{
+		{
+			"plain match",
+			args{
+				name: "git",
+				arg:  []string{"rev-parse", "--abbrev-ref", "HEAD"},
+			},
+			"main\n",
+			"",
+			"",
+		},
+		{
+			"env match",
+			args{
+				name: "git",
+				arg:  []string{"rev-parse", "--abbrev-ref", "HEAD"},
+				env:  map[string]string{"GIT_BRANCH": "release-1.2"},
+			},
+			"release-1.2\n",
+			"",
+			"",
+		},
+		{
+			"env value does not match",
+			args{
+				name: "git",
+				arg:  []string{"rev-parse", "--abbrev-ref", "HEAD"},
+				env:  map[string]string{"GIT_BRANCH": "feature"},
+			},
+			"main\n",
+			"",
+			"",
+		},
+		{
+			"wildcard match",
+			args{
+				name: "git",
+				arg:  []string{"log", "-n", "3"},
+			},
+			"3 commits\n",
+			"",
+			"",
+		},
+		{
+			"variadic match with exit code",
+			args{
+				name: "git",
+				arg:  []string{"push", "origin", "main"},
+			},
+			"",
+			"fatal: could not push origin main\n",
+			"exit status 128",
+		},
+		{
+			"no match",
+			args{
+				name: "git",
+				arg:  []string{"status"},
+			},
+			"",
+			"puffin: no fake matches \"git status\"\n",
+			"exit status 127",
+		},
+		{
+			"stdin match",
+			args{
+				name:  "tr",
+				arg:   []string{"a-z", "A-Z"},
+				stdin: "hello\n",
+			},
+			"HELLO\n",
+			"",
+			"",
+		},
+		{
+			"stdin does not match",
+			args{
+				name:  "tr",
+				arg:   []string{"a-z", "A-Z"},
+				stdin: "goodbye\n",
+			},
+			"",
+			"puffin: no fake matches \"tr a-z A-Z\"\n",
+			"exit status 127",
+		},
+		{
+			"json fake",
+			args{
+				name: "helm",
+				arg:  []string{"install", "puffin", "./chart", "--wait"},
+			},
+			"installed puffin\n",
+			"",
+			"",
+		},
+		{
+			"json fallback",
+			args{
+				name: "helm",
+				arg:  []string{"upgrade"},
+			},
+			"",
+			"unsupported\n",
+			"exit status 1",
+		},
+		{
+			"yaml fake",
+			args{
+				name: "kubectl",
+				arg:  []string{"get", "pods", "-n", "prod"},
+				env:  map[string]string{"KUBECONFIG": "/etc/kube"},
+			},
+			"NAME   READY\napi    1/1 in prod\n",
+			"",
+			"",
+		},
+		{
+			"yaml stdin match",
+			args{
+				name:  "kubectl",
+				arg:   []string{"apply", "-f", "-"},
+				stdin: "kind: Pod\n",
+			},
+			"applied -f -",
+			"",
+			"",
+		},
+		{
+			"yaml fallback",
+			args{
+				name: "kubectl",
+				arg:  []string{"get", "pods", "-n", "prod"},
+			},
+			"",
+			"error: unknown command \"get pods -n prod\"",
+			"exit status 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := NewFuncExec(WithEnv(tt.args.env), WithFakes(fakes))
+
+			cmd := exec.Command(tt.args.name, tt.args.arg...)
+			stdout, stderr := &strings.Builder{}, &strings.Builder{}
+			cmd.SetStdout(stdout)
+			cmd.SetStderr(stderr)
+			cmd.SetStdin(strings.NewReader(tt.args.stdin))
+
+			err := cmd.Run()
+			var gotErr string
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("Run() error = %v, wantErr %v", gotErr, tt.wantErr)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("Run() stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("Run() stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestParseFake(t *testing.T) {
+	stdin := "input\n"
+	tests := []struct {
+		name    string
+		pattern string
+		body    string
+		want    Fake
+		wantErr bool
+	}{
+		{
+			"all directives",
+			`kubectl get "pods -A" *`,
+			"# a comment\n\nstdout one\nstdout two\nstderr warn\nstdin input\nenv KUBECONFIG\nexit 3\ndelay 2s\n",
+			Fake{
+				Command:  "kubectl",
+				Args:     []string{"get", "pods -A", "*"},
+				Env:      []string{"KUBECONFIG"},
+				Stdin:    &stdin,
+				Stdout:   "one\ntwo\n",
+				Stderr:   "warn\n",
+				ExitCode: 3,
+				Delay:    2 * time.Second,
+			},
+			false,
+		},
+		{
+			"bad directive",
+			"kubectl",
+			"stdot typo\n",
+			Fake{},
+			true,
+		},
+		{
+			"bad exit code",
+			"kubectl",
+			"exit one\n",
+			Fake{},
+			true,
+		},
+		{
+			"bad template",
+			"kubectl",
+			"stdout {{.Args\n",
+			Fake{},
+			true,
+		},
+		{
+			"missing command",
+			"",
+			"",
+			Fake{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFake(tt.pattern, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got.stdout, got.stderr = nil, nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFake() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeFuncs_delayCanceled(t *testing.T) {
+	funcs, err := FakeFuncs([]Fake{{Command: "slow", Delay: time.Minute, Stdout: "done"}})
+	if err != nil {
+		t.Fatalf("FakeFuncs() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+
+	exec := NewFuncExec(WithFuncMap(funcs))
+	out, err := exec.CommandContext(ctx, "slow").Output()
+	if err == nil {
+		t.Errorf("Output() expected the delay to be canceled")
+	}
+	if len(out) != 0 {
+		t.Errorf("Output() = %q, want no output", out)
+	}
+}
+
+func TestFakeFuncs_stdin(t *testing.T) {
+	input := "y\n"
+	funcs, err := FakeFuncs([]Fake{
+		{Command: "confirm", Args: []string{"--check"}, Stdin: &input, Stdout: "confirmed"},
+		{Command: "confirm", Args: []string{"--echo"}, Stdout: "{{.Stdin}}"},
+		{Command: "confirm", Args: []string{"--quiet"}, Stdout: "Stdin closed"},
+		{Command: "confirm", Args: []string{"..."}, Stdout: "skipped"},
+	})
+	if err != nil {
+		t.Fatalf("FakeFuncs() error = %v", err)
+	}
+	exec := NewFuncExec(WithFuncMap(funcs))
+
+	tests := []struct {
+		name  string
+		args  []string
+		close bool
+		want  string
+	}{
+		{"stdin checked", []string{"--check"}, true, "confirmed"},
+		{"stdin in template", []string{"--echo"}, true, "y\n"},
+		// the pipe is never closed, the fake must not wait for it
+		{"stdin not used", []string{"--yes"}, false, "skipped"},
+		{"stdin in the output text", []string{"--quiet"}, false, "Stdin closed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go func() {
+				pw.Write([]byte(input))
+				if tt.close {
+					pw.Close()
+				}
+			}()
+
+			cmd := exec.Command("confirm", tt.args...)
+			cmd.SetStdin(pr)
+
+			done := make(chan struct{})
+			var out []byte
+			var err error
+			go func() {
+				defer close(done)
+				out, err = cmd.Output()
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Output() did not return, the fake is waiting for stdin")
+			}
+
+			if err != nil || string(out) != tt.want {
+				t.Errorf("Output() = %q, %v, want %q", out, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFakes_invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		fakes []Fake
+	}{
+		{"missing command", []Fake{{Stdout: "hello"}}},
+		{"bad template", []Fake{{Command: "git", Stdout: "{{.Match"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := NewFuncExec(WithFakes(tt.fakes))
+
+			cmd := exec.Command("git", "status")
+			if cmd.Err() == nil {
+				t.Fatalf("Err() expected the invalid fake to be reported")
+			}
+			if err := cmd.Run(); err != cmd.Err() {
+				t.Errorf("Run() error = %v, want %v", err, cmd.Err())
+			}
+		})
+	}
+}
+
+func TestParseFakesYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr bool
+	}{
+		{"empty", "# no fakes\n", 0, false},
+		{"fakes", "- command: git\n  stdin:\n- command: helm\n  exit: 2\n", 2, false},
+		{"not a sequence", "command: git\n", 0, true},
+		{"not a mapping", "- git\n", 0, true},
+		{"unknown key", "- command: git\n  stdot: typo\n", 0, true},
+		{"bad exit code", "- command: git\n  exit: one\n", 0, true},
+		{"bad delay", "- command: git\n  delay: soon\n", 0, true},
+		{"args not a list", "- command: git\n  args: push\n", 0, true},
+		{"missing command", "- stdout: hello\n", 0, true},
+		{"bad yaml", "- command: \"git\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFakesYAML([]byte(tt.data))
+			if err == nil {
+				_, err = FakeFuncs(got)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFakesYAML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got) != tt.want {
+				t.Errorf("ParseFakesYAML() = %d fakes, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestFake_readsStdin(t *testing.T) {
+	tests := []struct {
+		stdout string
+		want   bool
+	}{
+		{"{{.Stdin}}", true},
+		{"Stdin closed", false},
+		{"{{.Args}} {{.Env.Stdin}}", false},
+		{"{{$.Stdin}}", true},
+		{"{{$data := .}}{{$data.Stdin}}", true},
+		{"{{with .}}{{.Stdin}}{{end}}", true},
+		{"{{if .Stdin}}input{{else}}none{{end}}", true},
+		{"{{range .Args}}{{.}}{{end}}", false},
+		{"{{printf \"%s\" (.Stdin)}}", true},
+		{"{{define \"in\"}}{{.Stdin}}{{end}}{{template \"in\" .}}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stdout, func(t *testing.T) {
+			f := Fake{Command: "cat", Stdout: tt.stdout}
+			if err := f.compile(); err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			if f.readsStdin != tt.want {
+				t.Errorf("readsStdin = %v, want %v", f.readsStdin, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		args     []string
+		want     []string
+		wantOk   bool
+	}{
+		{"literal", []string{"log", "-n"}, []string{"log", "-n"}, nil, true},
+		{"wildcard", []string{"log", "-n", "*"}, []string{"log", "-n", "3"}, []string{"3"}, true},
+		{"wildcard matching itself", []string{"add", "*", "a*"}, []string{"add", "*", "a*"}, []string{"*", "a*"}, true},
+		{"escaped", []string{`a\*`, "?"}, []string{"a*", "b"}, []string{"b"}, true},
+		{"variadic", []string{"push", "..."}, []string{"push", "origin", "main"}, []string{"origin", "main"}, true},
+		{"too many args", []string{"status"}, []string{"status", "-s"}, nil, false},
+		{"too few args", []string{"log", "*"}, []string{"log"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchArgs(tt.patterns, tt.args)
+			if ok != tt.wantOk {
+				t.Fatalf("matchArgs() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package puffin
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// FlagFunc handles a fake command once its flags have been parsed.
+// flags is the value returned by the FlagCmd define function and args
+// are the arguments that remain after parsing
+type FlagFunc[T any] func(fc *FuncCmd, flags T, args []string) int
+
+// FlagCmd returns a CmdFunc that parses the commands arguments with a flag.FlagSet.
+// define registers the flags on the FlagSet and returns the typed values that are
+// passed to fn once parsing succeeds, for example
+//
+//	type planFlags struct {
+//		out   *string
+//		input *bool
+//	}
+//
+//	puffin.FlagCmd(
+//		func(fs *flag.FlagSet) planFlags {
+//			return planFlags{out: fs.String("out", "", "plan file"), input: fs.Bool("input", true, "ask for input")}
+//		},
+//		func(fc *puffin.FuncCmd, flags planFlags, args []string) int { ... },
+//	)
+//
+// Like a real command line tool, unknown flags or bad values print the usage to stderr
+// and exit with code 2, while -h or --help print the usage to stdout and exit with code 0
+func FlagCmd[T any](define func(fs *flag.FlagSet) T, fn FlagFunc[T]) CmdFunc {
+	return func(fc *FuncCmd) int {
+		fs := flag.NewFlagSet(filepath.Base(fc.Args()[0]), flag.ContinueOnError)
+		flags := define(fs)
+
+		var usage bytes.Buffer
+		fs.SetOutput(&usage)
+
+		err := fs.Parse(fc.Args()[1:])
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			orDiscard(fc.Stdout()).Write(usage.Bytes())
+			return 0
+		case err != nil:
+			orDiscard(fc.Stderr()).Write(usage.Bytes())
+			return 2
+		}
+
+		return fn(fc, flags, fs.Args())
+	}
+}
+
+// Subcommands returns a CmdFunc that runs the subcommand named by the commands first argument
+func Subcommands(cmds map[string]CmdFunc) CmdFunc {
+	return func(fc *FuncCmd) int {
+		return RunSubcommand(fc, fc.Args()[1:], cmds)
+	}
+}
+
+// RunSubcommand runs the subcommand named by args[0]. The subcommand is passed a FuncCmd
+// whose Args() are the parent and subcommand names as the first argument followed by the
+// rest of args, everything else is shared with fc and fc itself is not changed.
+// This allows subcommands to be used after global flags have been parsed by a FlagCmd, e.g.
+//
+//	puffin.FlagCmd(defineGlobals, func(fc *puffin.FuncCmd, globals Globals, args []string) int {
+//		return puffin.RunSubcommand(fc, args, cmds)
+//	})
+//
+// A missing or unknown subcommand prints the usage to stderr and exits with code 2,
+// help, -h or --help print the usage to stdout and exit with code 0
+func RunSubcommand(fc *FuncCmd, args []string, cmds map[string]CmdFunc) int {
+	name := filepath.Base(fc.Args()[0])
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			subcommandUsage(orDiscard(fc.Stdout()), name, cmds)
+			return 0
+		}
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(orDiscard(fc.Stderr()), "%s: missing command\n", name)
+		subcommandUsage(orDiscard(fc.Stderr()), name, cmds)
+		return 2
+	}
+
+	sub, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintf(orDiscard(fc.Stderr()), "%s: unknown command %q\n", name, args[0])
+		subcommandUsage(orDiscard(fc.Stderr()), name, cmds)
+		return 2
+	}
+
+	return sub(fc.withArgs(append([]string{fc.args[0] + " " + args[0]}, args[1:]...)))
+}
+
+// subcommandUsage writes the usage of a command with subcommands
+func subcommandUsage(w io.Writer, name string, cmds map[string]CmdFunc) {
+	names := make([]string, 0, len(cmds))
+	for sub := range cmds {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage: %s <command> [arguments]\n\nCommands:\n", name)
+	for _, sub := range names {
+		fmt.Fprintf(w, "  %s\n", sub)
+	}
+}
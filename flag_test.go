@@ -0,0 +1,170 @@
+package puffin
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+type helmFlags struct {
+	namespace *string
+	wait      *bool
+}
+
+func defineHelmFlags(fs *flag.FlagSet) helmFlags {
+	return helmFlags{
+		namespace: fs.String("namespace", "default", "namespace scope for this request"),
+		wait:      fs.Bool("wait", false, "wait until all resources are ready"),
+	}
+}
+
+func TestFlagCmd(t *testing.T) {
+	install := FlagCmd(defineHelmFlags, func(fc *FuncCmd, flags helmFlags, args []string) int {
+		fc.Stdout().Write([]byte(fc.Args()[0] + " " + strings.Join(args, ",") + " in " + *flags.namespace))
+		if *flags.wait {
+			fc.Stdout().Write([]byte(" and waited"))
+		}
+		return 0
+	})
+
+	helm := FlagCmd(
+		func(fs *flag.FlagSet) *bool { return fs.Bool("debug", false, "enable verbose output") },
+		func(fc *FuncCmd, debug *bool, args []string) int {
+			return RunSubcommand(fc, args, map[string]CmdFunc{
+				"install": install,
+				"version": Respond("v3.11.0", "", 0),
+			})
+		},
+	)
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr string
+		wantErr    string
+	}{
+		{
+			"subcommand with flags",
+			[]string{"--debug", "install", "-namespace", "prod", "--wait", "puffin", "./chart"},
+			"helm install puffin,./chart in prod and waited",
+			"",
+			"",
+		},
+		{
+			"subcommand defaults",
+			[]string{"install", "puffin"},
+			"helm install puffin in default",
+			"",
+			"",
+		},
+		{
+			"simple subcommand",
+			[]string{"version"},
+			"v3.11.0",
+			"",
+			"",
+		},
+		{
+			"unknown flag",
+			[]string{"install", "--atomic", "puffin"},
+			"",
+			"flag provided but not defined: -atomic\nUsage of helm install:\n" +
+				"  -namespace string\n    \tnamespace scope for this request (default \"default\")\n" +
+				"  -wait\n    \twait until all resources are ready\n",
+			"exit status 2",
+		},
+		{
+			"help flag",
+			[]string{"install", "--help"},
+			"Usage of helm install:\n" +
+				"  -namespace string\n    \tnamespace scope for this request (default \"default\")\n" +
+				"  -wait\n    \twait until all resources are ready\n",
+			"",
+			"",
+		},
+		{
+			"missing subcommand",
+			[]string{"--debug"},
+			"",
+			"helm: missing command\nUsage: helm <command> [arguments]\n\nCommands:\n  install\n  version\n",
+			"exit status 2",
+		},
+		{
+			"unknown subcommand",
+			[]string{"upgrade"},
+			"",
+			"helm: unknown command \"upgrade\"\nUsage: helm <command> [arguments]\n\nCommands:\n  install\n  version\n",
+			"exit status 2",
+		},
+		{
+			"subcommand help",
+			[]string{"help"},
+			"Usage: helm <command> [arguments]\n\nCommands:\n  install\n  version\n",
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := NewFuncExec(WithFuncMap(map[string]CmdFunc{"/usr/local/bin/helm": helm}))
+			cmd := exec.Command("helm", tt.args...)
+
+			stdout, stderr := &strings.Builder{}, &strings.Builder{}
+			cmd.SetStdout(stdout)
+			cmd.SetStderr(stderr)
+
+			err := cmd.Run()
+			var gotErr string
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("FlagCmd() error = %v, wantErr %v", gotErr, tt.wantErr)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("FlagCmd() stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("FlagCmd() stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+			if got := cmd.Args()[0]; got != "helm" {
+				t.Errorf("FlagCmd() modified the command args, got %s", got)
+			}
+		})
+	}
+}
+
+func TestRunSubcommand_args(t *testing.T) {
+	running, release := make(chan struct{}), make(chan struct{})
+	kubectl := Subcommands(map[string]CmdFunc{
+		"apply": func(fc *FuncCmd) int {
+			close(running)
+			<-release
+			if got := strings.Join(fc.Args(), ","); got != "kubectl apply,-f,app.yaml" {
+				fc.Stderr().Write([]byte(got))
+				return 1
+			}
+			return 0
+		},
+	})
+
+	exec := NewFuncExec(WithDefaultFunc(kubectl))
+	cmd := exec.Command("kubectl", "apply", "-f", "app.yaml")
+	stderr := &strings.Builder{}
+	cmd.SetStderr(stderr)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// the args of the Cmd can be read while the subcommand runs
+	<-running
+	if got := cmd.String(); got != "kubectl apply -f app.yaml" {
+		t.Errorf("Cmd.String() = %q while the subcommand runs", got)
+	}
+	close(release)
+
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Wait() error = %v, subcommand args = %q", err, stderr.String())
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 )
 
@@ -20,8 +21,10 @@ const PidMax = 32768
 // FuncExec is an Exec implementation that uses provided go functions
 // rather than the os/exec package
 type FuncExec struct {
-	funcMap map[string]CmdFunc
-	envs    map[string]string
+	funcMap     map[string]CmdFunc
+	defaultFunc CmdFunc
+	envs        map[string]string
+	err         error
 }
 
 // NewFuncExec creates a new FuncExec struct
@@ -36,8 +39,8 @@ func NewFuncExec(opts ...FuncExecOption) Exec {
 }
 
 // Lookpath finds a function in the function map and returns its name.
-// If the funcMap does not contain the named command (or a matching path),
-// an error will be returned
+// If the funcMap does not contain the named command (or a matching path)
+// and there is no default func, an error will be returned
 //
 // commands will be matched by their exact name first and then
 // by a matching file path in random order
@@ -73,6 +76,9 @@ func (e *FuncExec) Command(name string, arg ...string) Cmd {
 			cmd.err = err
 		}
 	}
+	if e.err != nil {
+		cmd.err = e.err
+	}
 
 	return cmd
 }
@@ -89,13 +95,9 @@ func (e *FuncExec) CommandContext(ctx context.Context, name string, arg ...strin
 	return cmd
 }
 
-// findFunc retrives a function and the command name from the func map
+// findFunc retrives a function and the command name from the func map.
+// If there is no match, the default func is returned with the name unchanged
 func (e *FuncExec) findFunc(name string) (CmdFunc, string) {
-	// dont even need to check the map if it's nil
-	if e.funcMap == nil {
-		return nil, ""
-	}
-
 	// check if it's a simple member of the map
 	if fn, ok := e.funcMap[name]; ok {
 		return fn, name
@@ -109,6 +111,10 @@ func (e *FuncExec) findFunc(name string) (CmdFunc, string) {
 		}
 	}
 
+	if e.defaultFunc != nil {
+		return e.defaultFunc, name
+	}
+
 	// no match was found
 	return nil, ""
 }
@@ -123,6 +129,14 @@ func WithFuncMap(funcs map[string]CmdFunc) FuncExecOption {
 	}
 }
 
+// WithDefaultFunc sets the func used for commands that are not in the func map.
+// With a default func, LookPath succeeds for every command
+func WithDefaultFunc(fn CmdFunc) FuncExecOption {
+	return func(fExec *FuncExec) {
+		fExec.defaultFunc = fn
+	}
+}
+
 // WithEnv sets the env used by all the commands created by this Exec
 func WithEnv(envs map[string]string) FuncExecOption {
 	return func(fExec *FuncExec) {
@@ -155,6 +169,13 @@ type FuncCmd struct {
 	startErr error
 	exitCode chan int
 
+	// exited is closed once the CmdFunc returns, killed is closed when Stop kills the command
+	exited     chan struct{}
+	killed     chan struct{}
+	stopMu     sync.Mutex
+	stopSignal os.Signal
+	signals    chan os.Signal
+
 	fExec *FuncExec
 }
 
@@ -260,15 +281,16 @@ func (c *FuncCmd) Start() error {
 
 	fn, _ := c.fExec.findFunc(c.path)
 	if fn == nil {
-		c.startErr = errors.New("exit status 1")
+		c.startErr = &exitError{code: 1}
 		return nil
 	}
 
-	done := make(chan struct{})
+	done, killed := make(chan struct{}), make(chan struct{})
+	c.exited, c.killed = done, killed
 	// start the command function in a go routine
 	go func() {
 		c.exitCode <- fn(c)
-		done <- struct{}{}
+		close(done)
 	}()
 
 	// listen for the command to be canceled if ctx is not nil
@@ -278,6 +300,9 @@ func (c *FuncCmd) Start() error {
 			case <-c.ctx.Done():
 				c.lock()
 				c.ctxErr <- c.ctx.Err()
+			case <-killed:
+				c.lock()
+				c.ctxErr <- &exitError{code: -1, signal: os.Kill}
 			case <-done:
 				c.ctxErr <- nil
 			}
@@ -365,14 +390,40 @@ func (c *FuncCmd) Wait() error {
 		}
 	}
 
-	exitCode := <-c.exitCode
+	var exitCode int
+	select {
+	case exitCode = <-c.exitCode:
+	case <-c.killed:
+		c.lock()
+		return &exitError{code: -1, signal: os.Kill}
+	}
 	if exitCode != 0 {
-		return fmt.Errorf("exit status %d", exitCode)
+		return &exitError{code: exitCode}
 	}
 
 	return nil
 }
 
+// exitError is the error returned by a FuncCmd when its CmdFunc returns a non-zero exit code,
+// or when it was killed by Stop. Like exec.ExitError, it reports the exit code through its
+// ExitCode method, which is -1 if the command was killed
+type exitError struct {
+	code   int
+	signal os.Signal
+}
+
+func (e *exitError) Error() string {
+	if e.signal != nil {
+		return "signal: " + e.signal.String()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// ExitCode returns the exit code returned by the CmdFunc
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
 // lock, prevents further changes to the underlying commands buffers
 func (c *FuncCmd) lock() {
 	if r, ok := c.stdin.(*lockableBuffer); ok {
@@ -408,20 +459,55 @@ func (c *FuncCmd) SetArgs(args []string) {
 	c.args = args
 }
 
+// withArgs returns a FuncCmd with args that shares its configuration, standard input and
+// output, context and Signals with c. It's used to run a CmdFunc from inside another one
+// without changing the args of the running Cmd, the returned Cmd can't be started or waited on
+func (c *FuncCmd) withArgs(args []string) *FuncCmd {
+	// signals are created lazily, create them now so both Cmds get the same channel
+	c.Signals()
+	c.stopMu.Lock()
+	signals := c.signals
+	c.stopMu.Unlock()
+
+	return &FuncCmd{
+		path: c.path,
+		args: args,
+		env:  c.env,
+		dir:  c.dir,
+
+		stdin:  c.stdin,
+		stdout: c.stdout,
+		stderr: c.stderr,
+
+		extraFiles:  c.extraFiles,
+		sysProcAttr: c.sysProcAttr,
+		process:     c.process,
+
+		ctx:        c.ctx,
+		stopSignal: c.stopSignal,
+		signals:    signals,
+
+		fExec: c.fExec,
+		err:   errors.New("puffin: subcommands can not be started"),
+	}
+}
+
 // Env returns the Cmd env
 func (c *FuncCmd) Env() []string {
 	return fmtEnv(c.env)
 }
 
-// SetEnv sets the Cmd env
+// SetEnv replaces the Cmd env. Like exec.Cmd, a nil env means the command
+// uses the env of the Exec that created it
 func (c *FuncCmd) SetEnv(env []string) {
+	if env == nil {
+		c.env = nil
+		return
+	}
+
+	c.env = make(map[string]string, len(env))
 	for _, e := range env {
-		nameVal := strings.Split(e, "=")
-		name := nameVal[0]
-		var val string
-		if len(nameVal) == 1 {
-			val = nameVal[1]
-		}
+		name, val, _ := strings.Cut(e, "=")
 		c.env[name] = val
 	}
 }
@@ -489,6 +575,11 @@ func (c *FuncCmd) SysProcAttr() *syscall.SysProcAttr {
 	return c.sysProcAttr
 }
 
+// SetSysProcAttr sets the Cmd sys proc attr
+func (c *FuncCmd) SetSysProcAttr(attr *syscall.SysProcAttr) {
+	c.sysProcAttr = attr
+}
+
 // Process returns the Cmd process
 func (c *FuncCmd) Process() *os.Process {
 	return c.process
@@ -503,3 +594,30 @@ func (c *FuncCmd) ProcessState() *os.ProcessState {
 func (c *FuncCmd) Err() error {
 	return c.err
 }
+
+// Context returns the context the Cmd was created with.
+// If the Cmd was created without a context, context.Background is returned
+func (c *FuncCmd) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// TraceContext returns the trace context passed to the command. The TRACEPARENT env var is
+// checked first, just like a real traced tool would, and then the commands context
+func (c *FuncCmd) TraceContext() (SpanContext, bool) {
+	for _, kv := range c.Environ() {
+		name, val, _ := strings.Cut(kv, "=")
+		if name != TraceparentEnv {
+			continue
+		}
+
+		sc, err := ParseTraceparent(val)
+		if err == nil {
+			return sc, true
+		}
+	}
+
+	return SpanContextFromContext(c.Context())
+}
@@ -866,6 +866,46 @@ func TestFuncCmd_Environ(t *testing.T) {
 	}
 }
 
+func TestFuncCmd_SetEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		set  []string
+		want []string
+	}{
+		{
+			"nil env",
+			nil,
+			[]string{"TEST=true", "EMPTY="},
+			[]string{"EMPTY=", "TEST=true"},
+		},
+		{
+			"replace env",
+			map[string]string{"OLD": "true"},
+			[]string{"URL=a=b", "FLAG"},
+			[]string{"FLAG=", "URL=a=b"},
+		},
+		{
+			"reset env",
+			map[string]string{"OLD": "true"},
+			nil,
+			[]string{"EXEC=10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &FuncCmd{
+				env:   tt.env,
+				fExec: &FuncExec{envs: map[string]string{"EXEC": "10"}},
+			}
+			c.SetEnv(tt.set)
+			if got := c.Environ(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FuncCmd.Environ() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestFuncCmd_Output(t *testing.T) {
 	type fields struct {
 		path     string
@@ -0,0 +1,67 @@
+// Package txtar parses the txtar archive format used by the go tool's test
+// scripts. An archive is a comment followed by a list of files, where each
+// file starts with a marker line of the form "-- name --".
+package txtar
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Archive is a parsed txtar archive
+type Archive struct {
+	Comment []byte
+	Files   []File
+}
+
+// File is a single file in an archive
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Parse parses the txtar archive in data. Parse never fails, any text
+// before the first file marker is returned as the archive comment
+func Parse(data []byte) *Archive {
+	a := &Archive{}
+
+	var name string
+	a.Comment, name, data = nextFile(data)
+	for name != "" {
+		f := File{Name: name}
+		f.Data, name, data = nextFile(data)
+		a.Files = append(a.Files, f)
+	}
+
+	return a
+}
+
+// nextFile splits data at the next file marker, returning the text before the marker,
+// the name of the next file and the text after the marker line
+func nextFile(data []byte) (before []byte, name string, after []byte) {
+	for i := 0; i < len(data); {
+		line := data[i:]
+		end := bytes.IndexByte(line, '\n')
+		if end >= 0 {
+			line = line[:end+1]
+		}
+
+		if name, ok := marker(line); ok {
+			return data[:i], name, data[i+len(line):]
+		}
+		i += len(line)
+	}
+
+	return data, "", nil
+}
+
+// marker reports whether line is a file marker and returns the file name if it is
+func marker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") || len(s) < 7 {
+		return "", false
+	}
+
+	name := strings.TrimSpace(s[3 : len(s)-3])
+	return name, name != ""
+}
@@ -0,0 +1,54 @@
+package txtar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *Archive
+	}{
+		{
+			"comment only",
+			"just a comment\n",
+			&Archive{Comment: []byte("just a comment\n")},
+		},
+		{
+			"files",
+			"comment\n-- a.txt --\nhello\n-- b/c.txt --\nworld\n",
+			&Archive{
+				Comment: []byte("comment\n"),
+				Files: []File{
+					{Name: "a.txt", Data: []byte("hello\n")},
+					{Name: "b/c.txt", Data: []byte("world\n")},
+				},
+			},
+		},
+		{
+			"empty file without trailing newline",
+			"-- empty --\n-- last --\nno newline",
+			&Archive{
+				Comment: []byte{},
+				Files: []File{
+					{Name: "empty", Data: []byte{}},
+					{Name: "last", Data: []byte("no newline")},
+				},
+			},
+		},
+		{
+			"not a marker",
+			"--  --\n-- a --b\n",
+			&Archive{Comment: []byte("--  --\n-- a --b\n")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package words splits a line of text into shell like words and joins words back into a line
+package words
+
+import (
+	"errors"
+	"strings"
+)
+
+// Split splits s into words separated by spaces or tabs.
+// Single quotes preserve everything they contain, double quotes allow
+// \" and \\ escapes and a backslash outside of quotes escapes the next character
+func Split(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case ' ', '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated \" quote")
+			}
+			inWord = true
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// Join joins words into a line that Split, or a shell, splits back into the same words
+func Join(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = Quote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quote quotes word with single quotes if it contains anything other than
+// letters, numbers and characters that are safe to use unquoted in a shell
+func Quote(word string) string {
+	if word == "" {
+		return "''"
+	}
+
+	for _, ch := range word {
+		if !isSafe(ch) {
+			return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		}
+	}
+	return word
+}
+
+// isSafe reports whether ch can be used in a shell word without quotes
+func isSafe(ch rune) bool {
+	switch {
+	case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_./:=@%+,", ch)
+}
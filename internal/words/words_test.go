@@ -0,0 +1,62 @@
+package words
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "  ", nil, false},
+		{"fields", "git  status\t--porcelain", []string{"git", "status", "--porcelain"}, false},
+		{"single quotes", `echo 'a "b" \c'`, []string{"echo", `a "b" \c`}, false},
+		{"double quotes", `echo "a \"b\" \c" x"y"z`, []string{"echo", `a "b" \c`, "xyz"}, false},
+		{"empty quotes", `echo ''`, []string{"echo", ""}, false},
+		{"escaped space", `echo a\ b`, []string{"echo", "a b"}, false},
+		{"unterminated single", `echo 'a`, nil, true},
+		{"unterminated double", `echo "a`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Split(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Split() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+		want  string
+	}{
+		{"plain", []string{"git", "commit", "--message=fix", "./a/b.go"}, "git commit --message=fix ./a/b.go"},
+		{"spaces", []string{"echo", "a b"}, "echo 'a b'"},
+		{"empty", []string{"echo", ""}, "echo ''"},
+		{"single quote", []string{"echo", "it's"}, `echo 'it'\''s'`},
+		{"shell characters", []string{"sh", "-c", "ls | wc -l; echo $HOME"}, "sh -c 'ls | wc -l; echo $HOME'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Join(tt.words)
+			if got != tt.want {
+				t.Errorf("Join() = %v, want %v", got, tt.want)
+			}
+
+			split, err := Split(got)
+			if err != nil || !reflect.DeepEqual(split, tt.words) {
+				t.Errorf("Split(Join()) = %q, %v, want %q", split, err, tt.words)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+// Package yaml parses the subset of yaml used by data files, like fake definitions.
+// Block mappings, block sequences, flow sequences of scalars, plain, single and double
+// quoted scalars, literal block scalars (| |- |+) and comments are supported. Anchors,
+// aliases, tags, flow mappings, folded scalars and multi line plain scalars are not
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses a yaml document. Mappings are returned as a map[string]any, sequences as
+// a []any and every scalar, including numbers and booleans, as a string. An empty document,
+// or an empty value, is returned as nil
+func Parse(data []byte) (any, error) {
+	p := &parser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, "\r")
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		if strings.HasPrefix(text[indent:], "\t") {
+			return nil, fmt.Errorf("line %d: tabs can not be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, line{num: i + 1, indent: indent, text: text[indent:], raw: text})
+	}
+
+	p.skip()
+	if p.pos < len(p.lines) && p.lines[p.pos].text == "---" {
+		p.pos++
+	}
+
+	node, err := p.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+
+	return node, nil
+}
+
+// line is a single line of the document, text is the line without its indentation
+type line struct {
+	num    int
+	indent int
+	text   string
+	raw    string
+}
+
+// parser parses a document line by line, pos is the index of the next line to parse
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// errorf returns an error for the current line
+func (p *parser) errorf(format string, args ...any) error {
+	num := len(p.lines)
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	}
+	return fmt.Errorf("line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+// skip moves past blank lines and comments
+func (p *parser) skip() {
+	for p.pos < len(p.lines) {
+		text := p.lines[p.pos].text
+		if text != "" && !strings.HasPrefix(text, "#") {
+			return
+		}
+		p.pos++
+	}
+}
+
+// peek returns the next line that is not blank or a comment, if its indent is at least indent
+func (p *parser) peek(indent int) (*line, bool) {
+	p.skip()
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent < indent {
+		return nil, false
+	}
+	return &p.lines[p.pos], true
+}
+
+// node parses the mapping, sequence or scalar that starts on the next line, if it's
+// indented by at least indent
+func (p *parser) node(indent int) (any, error) {
+	l, ok := p.peek(indent)
+	if !ok {
+		return nil, nil
+	}
+
+	if isItem(l.text) {
+		return p.sequence(l.indent)
+	}
+	if _, _, ok, err := splitKey(l.text); err != nil {
+		return nil, p.errorf("%s", err)
+	} else if ok {
+		return p.mapping(l.indent)
+	}
+
+	p.pos++
+	return p.value(l.text, l.indent)
+}
+
+// isItem checks if text is a block sequence item
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// sequence parses a block sequence whose items are indented by indent
+func (p *parser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for {
+		l, ok := p.peek(indent)
+		if !ok || l.indent != indent || !isItem(l.text) {
+			return items, nil
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			p.pos++
+			item, err := p.node(indent + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// the item is parsed as if it started on its own line, indented to where its text
+		// starts, so a mapping can continue on the following lines
+		l.indent += len(l.text) - len(rest)
+		l.text = rest
+		item, err := p.node(l.indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// mapping parses a block mapping whose keys are indented by indent
+func (p *parser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for {
+		l, ok := p.peek(indent)
+		if !ok || l.indent != indent || isItem(l.text) {
+			return m, nil
+		}
+
+		key, rest, ok, err := splitKey(l.text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if !ok {
+			return nil, p.errorf("expected a key")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			// the value is on the following lines, a sequence may be indented the same as the key
+			next, ok := p.peek(indent)
+			switch {
+			case ok && next.indent > indent:
+				m[key], err = p.node(indent + 1)
+			case ok && next.indent == indent && isItem(next.text):
+				m[key], err = p.sequence(indent)
+			default:
+				m[key] = nil
+			}
+		} else {
+			m[key], err = p.value(rest, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// value parses a value that starts on the previous line after a key or sequence item,
+// indent is the indent of the key or item. Block scalars continue on the following lines
+func (p *parser) value(text string, indent int) (any, error) {
+	switch text {
+	case "|", "|-", "|+":
+		return p.literal(text[1:], indent), nil
+	}
+
+	v, err := scalar(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.lines[p.pos-1].num, err)
+	}
+	if l, ok := p.peek(indent + 1); ok && l.indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+// literal parses the lines of a literal block scalar that are indented by more than indent.
+// chomp is "" to keep a single final line break, "-" to remove it and "+" to keep them all
+func (p *parser) literal(chomp string, indent int) string {
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		l := p.lines[p.pos]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if blockIndent < 0 {
+			blockIndent = l.indent
+		}
+		if l.indent <= indent || l.indent < blockIndent {
+			break
+		}
+		lines = append(lines, l.raw[blockIndent:])
+	}
+
+	// trailing blank lines belong to the block scalar, but they may be followed by more lines
+	text := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		text += "\n"
+	}
+	switch chomp {
+	case "+":
+		return text
+	case "-":
+		return strings.TrimRight(text, "\n")
+	}
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		text += "\n"
+	}
+	return text
+}
+
+// splitKey splits text into a mapping key and the rest of the line after the colon.
+// ok is false if text is not a mapping entry
+func splitKey(text string) (key, rest string, ok bool, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		end, err := quoteEnd(text)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := text[end+1:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		key, err := scalar(text[:end+1])
+		if err != nil {
+			return "", "", false, err
+		}
+		return key.(string), strings.TrimSpace(after[1:]), true, nil
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false, nil
+		}
+		i = len(text) - 1
+	}
+	if c := strings.Index(text, " #"); c >= 0 && c < i {
+		return "", "", false, nil
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true, nil
+}
+
+// scalar parses a single line scalar or a flow sequence of scalars
+func scalar(text string) (any, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	switch text[0] {
+	case '"', '\'':
+		end, err := quoteEnd(text)
+		if err != nil {
+			return nil, err
+		}
+		if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("unexpected text after the quoted value: %q", rest)
+		}
+		if text[0] == '\'' {
+			return strings.ReplaceAll(text[1:end], "''", "'"), nil
+		}
+		s, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid double quoted value %s", text[:end+1])
+		}
+		return s, nil
+	case '[':
+		return flow(text)
+	case '{', '&', '*', '!', '>':
+		return nil, fmt.Errorf("unsupported value %q", text)
+	}
+
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+	switch text {
+	case "~", "null":
+		return nil, nil
+	}
+	return text, nil
+}
+
+// quoteEnd returns the index of the quote that closes the quoted value text starts with
+func quoteEnd(text string) (int, error) {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case q == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated %c quote", q)
+}
+
+// flow parses a flow sequence of scalars like [a, "b c", 'd']
+func flow(text string) ([]any, error) {
+	items := []any{}
+	rest := strings.TrimSpace(text[1:])
+	for {
+		if rest == "" {
+			return nil, fmt.Errorf("unterminated flow sequence %s", text)
+		}
+		if rest[0] == ']' {
+			break
+		}
+
+		var item string
+		switch rest[0] {
+		case '"', '\'':
+			end, err := quoteEnd(rest)
+			if err != nil {
+				return nil, err
+			}
+			item, rest = rest[:end+1], rest[end+1:]
+		case '[', '{':
+			return nil, fmt.Errorf("nested flow collections are not supported")
+		default:
+			end := strings.IndexAny(rest, ",]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated flow sequence %s", text)
+			}
+			item, rest = rest[:end], rest[end:]
+		}
+
+		v, err := scalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+
+		rest = strings.TrimSpace(rest)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, fmt.Errorf("expected , or ] in flow sequence %s", text)
+		}
+	}
+
+	if after := strings.TrimSpace(rest[1:]); after != "" && !strings.HasPrefix(after, "#") {
+		return nil, fmt.Errorf("unexpected text after the flow sequence: %q", after)
+	}
+	return items, nil
+}
@@ -0,0 +1,78 @@
+package yaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    any
+		wantErr bool
+	}{
+		{"empty", "# nothing here\n", nil, false},
+		{"document start", "---\nhello\n", "hello", false},
+		{"plain scalar", "hello world # comment", "hello world", false},
+		{
+			"mapping",
+			"command: git\nexit: 128 # number\nempty:\nnull: ~\n",
+			map[string]any{"command": "git", "exit": "128", "empty": nil, "null": nil},
+			false,
+		},
+		{
+			"quoted values",
+			`a: "fatal: \"x\"\n"` + "\nb: 'it''s # not a comment'\n'c d': x\n",
+			map[string]any{"a": "fatal: \"x\"\n", "b": "it's # not a comment", "c d": "x"},
+			false,
+		},
+		{
+			"flow sequence",
+			`args: [install, "*", '...', ""]`,
+			map[string]any{"args": []any{"install", "*", "...", ""}},
+			false,
+		},
+		{
+			"block sequences",
+			"args:\n- push\n-   origin\nenv:\n  - A\n  -\n    B\n",
+			map[string]any{"args": []any{"push", "origin"}, "env": []any{"A", "B"}},
+			false,
+		},
+		{
+			"sequence of mappings",
+			"---\n- command: git\n  args: [push]\n\n- command: helm\n  nested:\n    key: value\n- - a\n  - b\n",
+			[]any{
+				map[string]any{"command": "git", "args": []any{"push"}},
+				map[string]any{"command": "helm", "nested": map[string]any{"key": "value"}},
+				[]any{"a", "b"},
+			},
+			false,
+		},
+		{
+			"literal block scalars",
+			"clip: |\n  one\n    two\n\n  # kept\n\nstrip: |-\n  three\n\nkeep: |+\n  four\n\nempty: |\nlast: x\n",
+			map[string]any{"clip": "one\n  two\n\n# kept\n", "strip": "three", "keep": "four\n\n", "empty": "", "last": "x"},
+			false,
+		},
+		{"tab indentation", "a:\n\tb: c\n", nil, true},
+		{"duplicate key", "a: 1\na: 2\n", nil, true},
+		{"bad indentation", "a: 1\n  b: 2\n", nil, true},
+		{"unterminated quote", `a: "b`, nil, true},
+		{"text after quote", `a: "b" c`, nil, true},
+		{"unterminated flow", "a: [b, c", nil, true},
+		{"flow mapping", "a: {b: c}", nil, true},
+		{"anchor", "a: &b c", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package puffin
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// priorityKey is the context key for the priority of a command
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that gives commands created with it the priority p.
+// When commands are waiting for a Limiter, higher priorities run first. The default is 0
+func WithPriority(ctx context.Context, p int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, or 0
+func PriorityFromContext(ctx context.Context) int {
+	p, _ := ctx.Value(priorityKey{}).(int)
+	return p
+}
+
+// Limiter limits the number of commands that run at the same time, both in total and per
+// command name. Commands that can't run yet wait in Start, highest priority first and in
+// the order they were started for equal priorities
+type Limiter struct {
+	max     int
+	perName map[string]int
+	key     func(cmd Cmd) string
+
+	mu       sync.Mutex
+	running  int
+	byName   map[string]int
+	waiting  []*limitWaiter
+	sequence uint64
+}
+
+// limitWaiter is a command waiting for the Limiter
+type limitWaiter struct {
+	name     string
+	priority int
+	seq      uint64
+	ready    chan struct{}
+}
+
+// LimitOption configures a Limiter
+type LimitOption func(*Limiter)
+
+// WithCommandLimit limits the number of commands with the given name that run at the same time
+func WithCommandLimit(name string, max int) LimitOption {
+	return func(l *Limiter) {
+		l.perName[name] = max
+	}
+}
+
+// WithLimitKey sets the function that returns the name used for per command limits.
+// The default is the base name of the command, e.g. git for /usr/bin/git
+func WithLimitKey(key func(cmd Cmd) string) LimitOption {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// NewLimiter creates a Limiter that allows max commands to run at the same time.
+// If max is 0 or less there is no total limit, only the per command limits
+func NewLimiter(max int, opts ...LimitOption) *Limiter {
+	l := &Limiter{
+		max:     max,
+		perName: map[string]int{},
+		key:     commandName,
+		byName:  map[string]int{},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Middleware returns a Middleware that makes Start wait until the Limiter allows the command
+// to run. If the commands context is done while it's waiting, Start returns the context error.
+// The slot is released once Wait returns, so every started command must be waited for
+func (l *Limiter) Middleware() Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		var name string
+		acquired := false
+
+		return &Hooks{
+			BeforeStart: func() error {
+				name = l.key(cmd)
+				if err := l.acquire(ctx, name); err != nil {
+					return err
+				}
+				acquired = true
+				return nil
+			},
+			Done: func(res Result) {
+				if acquired {
+					l.release(name)
+				}
+			},
+		}
+	})
+}
+
+// Running returns the number of commands that are running
+func (l *Limiter) Running() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.running
+}
+
+// Waiting returns the number of commands that are waiting to run
+func (l *Limiter) Waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.waiting)
+}
+
+// acquire waits until a command with the given name can run
+func (l *Limiter) acquire(ctx context.Context, name string) error {
+	l.mu.Lock()
+	l.sequence++
+	w := &limitWaiter{
+		name:     name,
+		priority: PriorityFromContext(ctx),
+		seq:      l.sequence,
+		ready:    make(chan struct{}),
+	}
+	l.waiting = append(l.waiting, w)
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		// the slot was granted while the context was canceled, give it to someone else
+		l.free(name)
+	default:
+		for i, waiting := range l.waiting {
+			if waiting == w {
+				l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// release frees the slot of a command with the given name
+func (l *Limiter) release(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.free(name)
+}
+
+// free frees a slot and lets waiting commands run. l.mu must be held
+func (l *Limiter) free(name string) {
+	l.running--
+	l.byName[name]--
+	if l.byName[name] <= 0 {
+		delete(l.byName, name)
+	}
+	l.dispatch()
+}
+
+// dispatch lets waiting commands run, highest priority first. A command that is blocked
+// by its per command limit does not hold up commands with other names. l.mu must be held
+func (l *Limiter) dispatch() {
+	sort.SliceStable(l.waiting, func(i, j int) bool {
+		if l.waiting[i].priority != l.waiting[j].priority {
+			return l.waiting[i].priority > l.waiting[j].priority
+		}
+		return l.waiting[i].seq < l.waiting[j].seq
+	})
+
+	remaining := l.waiting[:0]
+	for _, w := range l.waiting {
+		if !l.canRun(w.name) {
+			remaining = append(remaining, w)
+			continue
+		}
+
+		l.running++
+		l.byName[w.name]++
+		close(w.ready)
+	}
+	for i := len(remaining); i < len(l.waiting); i++ {
+		l.waiting[i] = nil
+	}
+	l.waiting = remaining
+}
+
+// canRun reports whether a command with the given name can run now. l.mu must be held
+func (l *Limiter) canRun(name string) bool {
+	if l.max > 0 && l.running >= l.max {
+		return false
+	}
+	if max, ok := l.perName[name]; ok && l.byName[name] >= max {
+		return false
+	}
+	return true
+}
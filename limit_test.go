@@ -0,0 +1,185 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gates blocks commands until they are released by name
+type gates struct {
+	mu    sync.Mutex
+	gates map[string]chan struct{}
+}
+
+// gate returns the channel that is closed when name is released
+func (g *gates) gate(name string) chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.gates == nil {
+		g.gates = map[string]chan struct{}{}
+	}
+	if _, ok := g.gates[name]; !ok {
+		g.gates[name] = make(chan struct{})
+	}
+	return g.gates[name]
+}
+
+// release lets the command called name complete
+func (g *gates) release(name string) {
+	close(g.gate(name))
+}
+
+// blockingExec returns an Exec whose commands block until their first argument is released,
+// the first argument of each command is sent on started once it is running
+func blockingExec(l *Limiter) (Exec, chan string, *gates) {
+	started, g := make(chan string, 10), &gates{}
+	block := func(fc *FuncCmd) int {
+		name := fc.Args()[1]
+		started <- name
+		<-g.gate(name)
+		return 0
+	}
+
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{"build": block, "test": block})),
+		l.Middleware(),
+	)
+	return exec, started, g
+}
+
+// waitFor polls until cond returns true
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatal("condition was never met")
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2)
+	exec, started, release := blockingExec(limiter)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		cmd := exec.Command("build", name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.Run()
+		}()
+	}
+
+	waitFor(t, func() bool { return limiter.Running() == 2 && limiter.Waiting() == 1 })
+	first, second := <-started, <-started
+	release.release(first)
+
+	third := <-started
+	if limiter.Running() != 2 || limiter.Waiting() != 0 {
+		t.Errorf("Limiter running %d waiting %d, want 2 and 0", limiter.Running(), limiter.Waiting())
+	}
+	release.release(second)
+	release.release(third)
+	wg.Wait()
+
+	if limiter.Running() != 0 {
+		t.Errorf("Limiter.Running() = %d, want 0", limiter.Running())
+	}
+}
+
+func TestLimiter_priority(t *testing.T) {
+	limiter := NewLimiter(1)
+	exec, started, release := blockingExec(limiter)
+
+	var wg sync.WaitGroup
+	run := func(ctx context.Context, name string) {
+		cmd := exec.CommandContext(ctx, "build", name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.Run()
+		}()
+	}
+
+	run(context.Background(), "running")
+	<-started
+
+	run(WithPriority(context.Background(), -5), "batch")
+	waitFor(t, func() bool { return limiter.Waiting() == 1 })
+	run(context.Background(), "normal")
+	waitFor(t, func() bool { return limiter.Waiting() == 2 })
+	run(WithPriority(context.Background(), 10), "interactive")
+	waitFor(t, func() bool { return limiter.Waiting() == 3 })
+
+	release.release("running")
+	for _, want := range []string{"interactive", "normal", "batch"} {
+		if got := <-started; got != want {
+			t.Fatalf("Limiter started %s, want %s", got, want)
+		}
+		release.release(want)
+	}
+	wg.Wait()
+}
+
+func TestLimiter_perCommand(t *testing.T) {
+	limiter := NewLimiter(0, WithCommandLimit("build", 1))
+	exec, _, release := blockingExec(limiter)
+
+	var wg sync.WaitGroup
+	for i, args := range [][]string{{"build", "b1"}, {"build", "b2"}, {"test", "t1"}} {
+		cmd := exec.Command(args[0], args[1])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.Run()
+		}()
+		waitFor(t, func() bool { return limiter.Running()+limiter.Waiting() == i+1 })
+	}
+
+	// b1 and t1 run, b2 waits for b1
+	if limiter.Running() != 2 || limiter.Waiting() != 1 {
+		t.Errorf("Limiter running %d waiting %d, want 2 and 1", limiter.Running(), limiter.Waiting())
+	}
+	release.release("b1")
+	waitFor(t, func() bool { return limiter.Waiting() == 0 })
+	release.release("b2")
+	release.release("t1")
+	wg.Wait()
+}
+
+func TestLimiter_canceled(t *testing.T) {
+	limiter := NewLimiter(1)
+	exec, started, release := blockingExec(limiter)
+
+	running := exec.Command("build", "running")
+	running.Start()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- exec.CommandContext(ctx, "build", "waiting").Start()
+	}()
+	waitFor(t, func() bool { return limiter.Waiting() == 1 })
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Start() error = %v, want context.Canceled", err)
+	}
+	if limiter.Waiting() != 0 {
+		t.Errorf("Limiter.Waiting() = %d, want 0", limiter.Waiting())
+	}
+
+	release.release("running")
+	running.Wait()
+	if limiter.Running() != 0 {
+		t.Errorf("Limiter.Running() = %d, want 0", limiter.Running())
+	}
+}
@@ -0,0 +1,163 @@
+package puffin
+
+import (
+	"context"
+	"sync"
+)
+
+// Logger writes structured log records as a message followed by alternating keys and values.
+// The methods match those of *slog.Logger so a slog logger can be used as a Logger directly
+type Logger interface {
+	Info(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// logConfig is the configuration for WithLogging
+type logConfig struct {
+	tailSize int
+	redactor *Redactor
+	redact   func(args []string) []string
+}
+
+// LogOption configures the logging middleware
+type LogOption func(*logConfig)
+
+// WithStderrTail sets the number of bytes from the end of stderr that are included in the
+// log record. The default is 512 bytes, 0 disables the stderr tail
+func WithStderrTail(n int) LogOption {
+	return func(cfg *logConfig) {
+		cfg.tailSize = n
+	}
+}
+
+// WithLogRedactor sets the Redactor used to remove secrets from the logged arguments,
+// stderr tail and error. The default is the DefaultRedactor, which is also used if r is nil.
+// An empty Redactor only redacts passwords in urls
+func WithLogRedactor(r *Redactor) LogOption {
+	return func(cfg *logConfig) {
+		if r == nil {
+			r = DefaultRedactor()
+		}
+		cfg.redactor = r
+	}
+}
+
+// WithArgRedaction sets the function used to redact secrets from the logged arguments,
+// it takes precedence over the Redactor for the arguments
+func WithArgRedaction(redact func(args []string) []string) LogOption {
+	return func(cfg *logConfig) {
+		cfg.redact = redact
+	}
+}
+
+// WithLogging returns a Middleware that writes a single log record for every command once it
+// has completed. Successful commands are logged with Info and failed commands with Error.
+// Each record has the following keys
+//
+//	args          the command line arguments with secrets redacted
+//	dir           the working directory of the command
+//	duration      how long the command ran for
+//	exit_code     the exit code of the command
+//	stdout_bytes  the number of bytes written to stdout
+//	stderr_bytes  the number of bytes written to stderr
+//	stderr_tail   the end of stderr, if anything was written to it
+//	error         the error returned by the command, if it failed
+//
+// Secrets are removed from the args, stderr tail and error with the DefaultRedactor,
+// WithLogRedactor and WithArgRedaction can be used to change this behavior
+func WithLogging(logger Logger, opts ...LogOption) Middleware {
+	cfg := &logConfig{
+		tailSize: 512,
+		redactor: DefaultRedactor(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.redact == nil {
+		cfg.redact = cfg.redactor.Args
+	}
+
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		stdout := &countWriter{}
+		stderr := &tailWriter{size: cfg.tailSize}
+
+		return &Hooks{
+			Stdout: stdout,
+			Stderr: stderr,
+			Done: func(res Result) {
+				args := cfg.redact(append([]string(nil), cmd.Args()...))
+				kvs := []any{
+					"args", args,
+					"dir", cmd.Dir(),
+					"duration", res.Duration,
+					"exit_code", res.ExitCode,
+					"stdout_bytes", stdout.count(),
+					"stderr_bytes", stderr.count(),
+				}
+				if tail := stderr.tail(); tail != "" {
+					kvs = append(kvs, "stderr_tail", cfg.redactor.String(tail))
+				}
+
+				if res.Err != nil {
+					kvs = append(kvs, "error", cfg.redactor.Error(res.Err).Error())
+					logger.Error("command failed", kvs...)
+					return
+				}
+				logger.Info("command finished", kvs...)
+			},
+		}
+	})
+}
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	mu sync.Mutex
+	n  int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// count returns the number of bytes written
+func (w *countWriter) count() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.n
+}
+
+// tailWriter counts the bytes written to it and keeps the last size bytes
+type tailWriter struct {
+	countWriter
+	size int
+	buf  []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.countWriter.Write(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size <= 0 {
+		return len(p), nil
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.size {
+		w.buf = append(w.buf[:0], w.buf[len(w.buf)-w.size:]...)
+	}
+	return len(p), nil
+}
+
+// tail returns the last bytes written
+func (w *tailWriter) tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return string(w.buf)
+}
@@ -0,0 +1,215 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default duration histogram buckets, in seconds, used by Metrics
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects metrics for every command run through its Middleware.
+// It implements http.Handler and serves the metrics in the Prometheus text format
+type Metrics struct {
+	mu       sync.Mutex
+	buckets  []float64
+	label    func(cmd Cmd) string
+	commands map[string]*commandMetrics
+}
+
+// commandMetrics are the metrics for a single command label
+type commandMetrics struct {
+	exits       map[int]uint64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+	inFlight    int64
+	stdoutBytes uint64
+	stderrBytes uint64
+}
+
+// MetricsOption configures Metrics
+type MetricsOption func(*Metrics)
+
+// WithBuckets sets the upper bounds, in seconds, of the duration histogram buckets
+func WithBuckets(buckets ...float64) MetricsOption {
+	return func(m *Metrics) {
+		m.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(m.buckets)
+	}
+}
+
+// WithCommandLabel sets the function used to get the command label of a Cmd.
+// The default label is the base name of the first argument, e.g. git for /usr/bin/git
+func WithCommandLabel(label func(cmd Cmd) string) MetricsOption {
+	return func(m *Metrics) {
+		m.label = label
+	}
+}
+
+// NewMetrics creates a new Metrics
+func NewMetrics(opts ...MetricsOption) *Metrics {
+	m := &Metrics{
+		buckets:  DefaultBuckets,
+		label:    commandName,
+		commands: map[string]*commandMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// commandName returns the base name of the command
+func commandName(cmd Cmd) string {
+	args := cmd.Args()
+	if len(args) == 0 {
+		return filepath.Base(cmd.Path())
+	}
+	return filepath.Base(args[0])
+}
+
+// Middleware returns a Middleware that records metrics for every command
+func (m *Metrics) Middleware() Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		label := m.label(cmd)
+		stdout, stderr := &countWriter{}, &countWriter{}
+		started := false
+
+		return &Hooks{
+			Stdout: stdout,
+			Stderr: stderr,
+			AfterStart: func(err error) error {
+				if err == nil {
+					started = true
+					m.update(label, func(cm *commandMetrics) {
+						cm.inFlight++
+					})
+				}
+				return err
+			},
+			Done: func(res Result) {
+				m.update(label, func(cm *commandMetrics) {
+					if started {
+						cm.inFlight--
+					}
+
+					cm.exits[res.ExitCode]++
+					cm.stdoutBytes += uint64(stdout.count())
+					cm.stderrBytes += uint64(stderr.count())
+
+					seconds := res.Duration.Seconds()
+					cm.sum += seconds
+					cm.count++
+					for i, bound := range m.buckets {
+						if seconds <= bound {
+							cm.buckets[i]++
+						}
+					}
+				})
+			},
+		}
+	})
+}
+
+// update calls fn with the metrics for the command label while holding the lock
+func (m *Metrics) update(label string, fn func(cm *commandMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cm, ok := m.commands[label]
+	if !ok {
+		cm = &commandMetrics{
+			exits:   map[int]uint64{},
+			buckets: make([]uint64, len(m.buckets)),
+		}
+		m.commands[label] = cm
+	}
+
+	fn(cm)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	labels := make([]string, 0, len(m.commands))
+	for label := range m.commands {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var b bytes.Buffer
+	b.WriteString("# HELP puffin_commands_total Number of commands that have completed, by exit code.\n")
+	b.WriteString("# TYPE puffin_commands_total counter\n")
+	for _, label := range labels {
+		cm := m.commands[label]
+		codes := make([]int, 0, len(cm.exits))
+		for code := range cm.exits {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&b, "puffin_commands_total{command=%s,exit_code=\"%d\"} %d\n", quoteLabel(label), code, cm.exits[code])
+		}
+	}
+
+	b.WriteString("# HELP puffin_command_duration_seconds Time taken for commands to complete.\n")
+	b.WriteString("# TYPE puffin_command_duration_seconds histogram\n")
+	for _, label := range labels {
+		cm := m.commands[label]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(&b, "puffin_command_duration_seconds_bucket{command=%s,le=\"%s\"} %d\n", quoteLabel(label), formatFloat(bound), cm.buckets[i])
+		}
+		fmt.Fprintf(&b, "puffin_command_duration_seconds_bucket{command=%s,le=\"+Inf\"} %d\n", quoteLabel(label), cm.count)
+		fmt.Fprintf(&b, "puffin_command_duration_seconds_sum{command=%s} %s\n", quoteLabel(label), formatFloat(cm.sum))
+		fmt.Fprintf(&b, "puffin_command_duration_seconds_count{command=%s} %d\n", quoteLabel(label), cm.count)
+	}
+
+	b.WriteString("# HELP puffin_commands_in_flight Number of commands that are currently running.\n")
+	b.WriteString("# TYPE puffin_commands_in_flight gauge\n")
+	for _, label := range labels {
+		fmt.Fprintf(&b, "puffin_commands_in_flight{command=%s} %d\n", quoteLabel(label), m.commands[label].inFlight)
+	}
+
+	b.WriteString("# HELP puffin_command_stdout_bytes_total Bytes written to stdout by commands.\n")
+	b.WriteString("# TYPE puffin_command_stdout_bytes_total counter\n")
+	for _, label := range labels {
+		fmt.Fprintf(&b, "puffin_command_stdout_bytes_total{command=%s} %d\n", quoteLabel(label), m.commands[label].stdoutBytes)
+	}
+
+	b.WriteString("# HELP puffin_command_stderr_bytes_total Bytes written to stderr by commands.\n")
+	b.WriteString("# TYPE puffin_command_stderr_bytes_total counter\n")
+	for _, label := range labels {
+		fmt.Fprintf(&b, "puffin_command_stderr_bytes_total{command=%s} %d\n", quoteLabel(label), m.commands[label].stderrBytes)
+	}
+	m.mu.Unlock()
+
+	return b.WriteTo(w)
+}
+
+// quoteLabel quotes a label value using the escaping rules of the Prometheus text format
+func quoteLabel(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// formatFloat formats a float the way the Prometheus text format expects
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
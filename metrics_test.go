@@ -0,0 +1,89 @@
+package puffin
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(WithBuckets(0.001, 10))
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"/usr/bin/git": Respond("abc\n", "", 0),
+			"kubectl":      Respond("", "forbidden\n", 1),
+			"slow":         Delay(5*time.Millisecond, Respond("", "", 0)),
+		})),
+		metrics.Middleware(),
+	)
+
+	exec.Command("git", "rev-parse", "HEAD").Run()
+	exec.Command("git", "status").Output()
+	exec.Command("kubectl", "get", "pods").Run()
+	exec.Command("missing").Run()
+	exec.Command("slow").Run()
+
+	// a running command shows up as in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	running := exec.CommandContext(ctx, "slow")
+	if err := running.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	cancel()
+	running.Wait()
+
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Metrics.ServeHTTP() content type = %q", got)
+	}
+
+	body := rec.Body.String()
+	wantLines := []string{
+		`puffin_commands_total{command="git",exit_code="0"} 2`,
+		`puffin_commands_total{command="kubectl",exit_code="1"} 1`,
+		`puffin_commands_total{command="missing",exit_code="-1"} 1`,
+		`puffin_command_duration_seconds_bucket{command="git",le="10"} 2`,
+		`puffin_command_duration_seconds_bucket{command="git",le="+Inf"} 2`,
+		`puffin_command_duration_seconds_bucket{command="slow",le="0.001"} 0`,
+		`puffin_command_duration_seconds_bucket{command="slow",le="10"} 1`,
+		`puffin_command_duration_seconds_count{command="slow"} 1`,
+		`puffin_commands_in_flight{command="git"} 0`,
+		`puffin_commands_in_flight{command="slow"} 1`,
+		`puffin_command_stdout_bytes_total{command="git"} 8`,
+		`puffin_command_stderr_bytes_total{command="kubectl"} 10`,
+		`# TYPE puffin_command_duration_seconds histogram`,
+	}
+	for _, line := range wantLines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics.ServeHTTP() missing line %s in\n%s", line, body)
+		}
+	}
+
+	// once the running command completes it is no longer in flight
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	if !strings.Contains(b.String(), `puffin_commands_in_flight{command="slow"} 0`) {
+		t.Errorf("Metrics.WriteTo() slow command is still in flight\n%s", b.String())
+	}
+}
+
+func Test_quoteLabel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"git", `"git"`},
+		{`a"b\c` + "\n", `"a\"b\\c\n"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := quoteLabel(tt.s); got != tt.want {
+				t.Errorf("quoteLabel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Middleware wraps an Exec to add behavior to every Cmd it creates
+type Middleware func(Exec) Exec
+
+// Wrap wraps base with each of the middleware. The first middleware is the outermost
+// so it is the first to see a Cmd start and the last to see it complete
+func Wrap(base Exec, mws ...Middleware) Exec {
+	for i := len(mws) - 1; i >= 0; i-- {
+		base = mws[i](base)
+	}
+
+	return base
+}
+
+// Result is the final result of running a Cmd
+type Result struct {
+	// ExitCode is the exit code of the command, see ExitCode
+	ExitCode int
+
+	// Err is the error returned by Start or Wait
+	Err error
+
+	// StartTime is the time the Cmd was started
+	StartTime time.Time
+
+	// Duration is how long the Cmd ran for
+	Duration time.Duration
+}
+
+// Hooks are called as a single Cmd moves through its life cycle. All fields are optional
+type Hooks struct {
+	// BeforeStart is called before the Cmd is started.
+	// If it returns an error the Cmd is not started and Start returns the error
+	BeforeStart func() error
+
+	// AfterStart is called with the error returned by Start, the error it returns replaces it
+	AfterStart func(err error) error
+
+	// BeforeWait is called before waiting for the Cmd to complete
+	BeforeWait func()
+
+	// AfterWait is called with the error returned by Wait, the error it returns replaces it
+	AfterWait func(err error) error
+
+	// Done is called exactly once for every Cmd that was started, or failed to start,
+	// with the final result of the Cmd
+	Done func(res Result)
+
+	// Stdout and Stderr receive a copy of the commands standard output and standard error.
+	// Errors returned by these writers are ignored so they can not affect the Cmd.
+	// When the same writer is used for both it must be safe for concurrent use
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// HookFunc is called for every Cmd created by the Exec and returns the hooks for that Cmd.
+// ctx is the context passed to CommandContext or context.Background if Command was used.
+// Returning nil leaves the Cmd unchanged
+type HookFunc func(ctx context.Context, cmd Cmd) *Hooks
+
+// WithHooks returns a Middleware that adds the hooks returned by fn to every Cmd
+func WithHooks(fn HookFunc) Middleware {
+	return func(base Exec) Exec {
+		return &hookExec{base: base, fn: fn}
+	}
+}
+
+// hookExec is an Exec that adds hooks to every Cmd created by the base Exec
+type hookExec struct {
+	base Exec
+	fn   HookFunc
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *hookExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd with the base Exec and adds hooks to it
+func (e *hookExec) Command(name string, arg ...string) Cmd {
+	return e.hook(context.Background(), e.base.Command(name, arg...))
+}
+
+// CommandContext creates a Cmd with the base Exec and adds hooks to it
+func (e *hookExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return e.hook(ctx, e.base.CommandContext(ctx, name, arg...))
+}
+
+// hook wraps cmd with the hooks returned by the HookFunc
+func (e *hookExec) hook(ctx context.Context, cmd Cmd) Cmd {
+	hooks := e.fn(ctx, cmd)
+	if hooks == nil {
+		return cmd
+	}
+
+	return &hookCmd{Cmd: cmd, hooks: hooks}
+}
+
+// hookCmd is a Cmd that calls hooks as it runs.
+// Run, Output and CombinedOutput are implemented with Start and Wait so that the hooks
+// are called no matter how the Cmd is run
+type hookCmd struct {
+	Cmd
+	hooks *Hooks
+
+	started   bool
+	done      bool
+	startTime time.Time
+
+	stdoutPiped bool
+	stderrPiped bool
+	restore     []func()
+}
+
+// Unwrap returns the Cmd wrapped by the hooks
+func (c *hookCmd) Unwrap() Cmd {
+	return c.Cmd
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error
+func (c *hookCmd) CombinedOutput() ([]byte, error) {
+	return combinedOutput(c)
+}
+
+// Output runs the command and returns its standard output
+func (c *hookCmd) Output() ([]byte, error) {
+	return output(c)
+}
+
+// Run starts the command and waits for it to complete
+func (c *hookCmd) Run() error {
+	return run(c)
+}
+
+// Start calls the start hooks and starts the command
+func (c *hookCmd) Start() error {
+	if c.started {
+		return c.Cmd.Start()
+	}
+	c.started = true
+	c.startTime = time.Now()
+
+	if c.hooks.BeforeStart != nil {
+		if err := c.hooks.BeforeStart(); err != nil {
+			c.finish(err)
+			return err
+		}
+	}
+
+	c.teeOutput()
+
+	// reset the start time so time spent in BeforeStart is not counted
+	c.startTime = time.Now()
+	err := c.Cmd.Start()
+
+	if c.hooks.AfterStart != nil {
+		err = c.hooks.AfterStart(err)
+	}
+	if err != nil {
+		c.finish(err)
+	}
+
+	return err
+}
+
+// Wait calls the wait hooks and waits for the command to complete
+func (c *hookCmd) Wait() error {
+	if !c.started || c.done {
+		return c.Cmd.Wait()
+	}
+
+	if c.hooks.BeforeWait != nil {
+		c.hooks.BeforeWait()
+	}
+
+	err := c.Cmd.Wait()
+
+	if c.hooks.AfterWait != nil {
+		err = c.hooks.AfterWait(err)
+	}
+	c.finish(err)
+
+	return err
+}
+
+// StdoutPipe returns a pipe connected to the commands standard output.
+// Everything read from the pipe is also written to the Stdout hook
+func (c *hookCmd) StdoutPipe() (io.ReadCloser, error) {
+	pipe, err := c.Cmd.StdoutPipe()
+	if err != nil || c.hooks.Stdout == nil {
+		return pipe, err
+	}
+
+	c.stdoutPiped = true
+	return &teeReadCloser{ReadCloser: pipe, w: c.hooks.Stdout}, nil
+}
+
+// StderrPipe returns a pipe connected to the commands standard error.
+// Everything read from the pipe is also written to the Stderr hook
+func (c *hookCmd) StderrPipe() (io.ReadCloser, error) {
+	pipe, err := c.Cmd.StderrPipe()
+	if err != nil || c.hooks.Stderr == nil {
+		return pipe, err
+	}
+
+	c.stderrPiped = true
+	return &teeReadCloser{ReadCloser: pipe, w: c.hooks.Stderr}, nil
+}
+
+// teeOutput sends a copy of the commands output to the Stdout and Stderr hooks
+func (c *hookCmd) teeOutput() {
+	teeStdout := c.hooks.Stdout != nil && !c.stdoutPiped
+	teeStderr := c.hooks.Stderr != nil && !c.stderrPiped
+	if !teeStdout && !teeStderr {
+		return
+	}
+
+	origStdout, origStderr := c.Cmd.Stdout(), c.Cmd.Stderr()
+	stdout, stderr := origStdout, origStderr
+	shared := sameWriter(stdout, stderr)
+	if shared {
+		// once it's wrapped, the shared writer will be written to from different go routines
+		sw := &syncWriter{w: stdout}
+		stdout, stderr = sw, sw
+	}
+
+	if teeStdout {
+		stdout = teeWriter(stdout, c.hooks.Stdout)
+	}
+	if teeStderr {
+		stderr = teeWriter(stderr, c.hooks.Stderr)
+	}
+
+	if teeStdout || shared {
+		c.Cmd.SetStdout(stdout)
+		c.restore = append(c.restore, func() { c.Cmd.SetStdout(origStdout) })
+	}
+	if teeStderr || shared {
+		c.Cmd.SetStderr(stderr)
+		c.restore = append(c.restore, func() { c.Cmd.SetStderr(origStderr) })
+	}
+}
+
+// finish calls the Done hook and restores the commands writers
+func (c *hookCmd) finish(err error) {
+	if c.done {
+		return
+	}
+	c.done = true
+
+	for _, restore := range c.restore {
+		restore()
+	}
+
+	if c.hooks.Done != nil {
+		c.hooks.Done(Result{
+			ExitCode:  ExitCode(err),
+			Err:       err,
+			StartTime: c.startTime,
+			Duration:  time.Since(c.startTime),
+		})
+	}
+}
+
+// ExitCode returns the exit code for an error returned by Run, Wait, Output or CombinedOutput.
+// A nil error is exit code 0. Errors that don't carry an exit code, like a failure to start
+// or a command that was killed by a signal, return -1
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+
+	return -1
+}
+
+// run starts cmd and waits for it to complete
+func run(cmd Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// output runs cmd and returns its standard output, it mirrors exec.Cmd.Output
+// but uses the Start and Wait methods of cmd
+func output(cmd Cmd) ([]byte, error) {
+	if cmd.Stdout() != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	stdout := &bytes.Buffer{}
+	cmd.SetStdout(stdout)
+
+	var stderr *bytes.Buffer
+	if cmd.Stderr() == nil {
+		stderr = &bytes.Buffer{}
+		cmd.SetStderr(stderr)
+	}
+
+	err := run(cmd)
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && stderr != nil {
+		ee.Stderr = stderr.Bytes()
+	}
+
+	return stdout.Bytes(), err
+}
+
+// combinedOutput runs cmd and returns its combined standard output and standard error,
+// it mirrors exec.Cmd.CombinedOutput but uses the Start and Wait methods of cmd
+func combinedOutput(cmd Cmd) ([]byte, error) {
+	if cmd.Stdout() != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if cmd.Stderr() != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+
+	b := &syncWriter{w: &bytes.Buffer{}}
+	cmd.SetStdout(b)
+	cmd.SetStderr(b)
+
+	err := run(cmd)
+	return b.w.(*bytes.Buffer).Bytes(), err
+}
+
+// syncWriter is an io.Writer that is safe for concurrent use
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
+
+// sameWriter reports whether a and b are the same writer
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// teeWriter returns a writer that writes to w and a copy to hook. Errors from hook are ignored
+func teeWriter(w, hook io.Writer) io.Writer {
+	if w == nil {
+		return ignoreErrors{hook}
+	}
+	return io.MultiWriter(w, ignoreErrors{hook})
+}
+
+// ignoreErrors is an io.Writer that ignores the errors of the underlying writer
+type ignoreErrors struct {
+	w io.Writer
+}
+
+func (w ignoreErrors) Write(p []byte) (int, error) {
+	w.w.Write(p)
+	return len(p), nil
+}
+
+// teeReadCloser is an io.ReadCloser that writes everything it reads to w
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (r *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.w.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,269 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordEvents returns a middleware that records every hook call in events
+func recordEvents(name string, events *[]string) Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		*events = append(*events, name+" command")
+		return &Hooks{
+			BeforeStart: func() error {
+				*events = append(*events, name+" before start")
+				return nil
+			},
+			AfterStart: func(err error) error {
+				*events = append(*events, name+" after start")
+				return err
+			},
+			BeforeWait: func() {
+				*events = append(*events, name+" before wait")
+			},
+			AfterWait: func(err error) error {
+				*events = append(*events, name+" after wait")
+				return err
+			},
+			Done: func(res Result) {
+				*events = append(*events, fmt.Sprintf("%s done %d", name, res.ExitCode))
+			},
+		}
+	})
+}
+
+func TestWrap(t *testing.T) {
+	var events []string
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{"test": Respond("", "", 3)})),
+		recordEvents("outer", &events),
+		recordEvents("inner", &events),
+	)
+
+	err := exec.Command("test").Run()
+	if ExitCode(err) != 3 {
+		t.Errorf("Wrap() error = %v, want exit status 3", err)
+	}
+
+	want := []string{
+		"inner command",
+		"outer command",
+		"outer before start",
+		"inner before start",
+		"inner after start",
+		"outer after start",
+		"outer before wait",
+		"inner before wait",
+		"inner after wait",
+		"inner done 3",
+		"outer after wait",
+		"outer done 3",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Wrap() events = %v, want %v", events, want)
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	funcs := map[string]CmdFunc{
+		"test": Respond("out", "err", 0),
+		"fail": Respond("", "failed", 2),
+	}
+
+	type result struct {
+		output   string
+		exitCode int
+		err      string
+	}
+	tests := []struct {
+		name       string
+		hooks      Hooks
+		cmd        string
+		run        func(cmd Cmd) ([]byte, error)
+		want       result
+		wantStdout string
+		wantStderr string
+		wantDone   bool
+	}{
+		{
+			"output",
+			Hooks{},
+			"test",
+			func(cmd Cmd) ([]byte, error) { return cmd.Output() },
+			result{"out", 0, ""},
+			"out",
+			"err",
+			true,
+		},
+		{
+			"combined output",
+			Hooks{},
+			"test",
+			func(cmd Cmd) ([]byte, error) { return cmd.CombinedOutput() },
+			result{"outerr", 0, ""},
+			"out",
+			"err",
+			true,
+		},
+		{
+			"stdout pipe",
+			Hooks{},
+			"test",
+			func(cmd Cmd) ([]byte, error) {
+				pipe, err := cmd.StdoutPipe()
+				if err != nil {
+					return nil, err
+				}
+				if err := cmd.Run(); err != nil {
+					return nil, err
+				}
+				return io.ReadAll(pipe)
+			},
+			result{"out", 0, ""},
+			"out",
+			"err",
+			true,
+		},
+		{
+			"exit code",
+			Hooks{},
+			"fail",
+			func(cmd Cmd) ([]byte, error) { return cmd.Output() },
+			result{"", 2, "exit status 2"},
+			"",
+			"failed",
+			true,
+		},
+		{
+			"before start error",
+			Hooks{
+				BeforeStart: func() error { return errors.New("not allowed") },
+			},
+			"test",
+			func(cmd Cmd) ([]byte, error) { return cmd.Output() },
+			result{"", -1, "not allowed"},
+			"",
+			"",
+			true,
+		},
+		{
+			"after wait replaces the error",
+			Hooks{
+				AfterWait: func(err error) error { return fmt.Errorf("wrapped: %w", err) },
+			},
+			"fail",
+			func(cmd Cmd) ([]byte, error) { return cmd.Output() },
+			result{"", 2, "wrapped: exit status 2"},
+			"",
+			"failed",
+			true,
+		},
+		{
+			"start failure",
+			Hooks{},
+			"missing",
+			func(cmd Cmd) ([]byte, error) { return cmd.Output() },
+			result{"", -1, `exec: "missing": executable file not found in $PATH`},
+			"",
+			"",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			var done *Result
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(funcs)),
+				WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+					hooks := tt.hooks
+					hooks.Stdout = stdout
+					hooks.Stderr = stderr
+					hooks.Done = func(res Result) {
+						if done != nil {
+							t.Errorf("WithHooks() Done was called more than once")
+						}
+						done = &res
+					}
+					return &hooks
+				}),
+			)
+
+			out, err := tt.run(exec.Command(tt.cmd))
+			got := result{output: string(out), exitCode: ExitCode(err)}
+			if err != nil {
+				got.err = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("WithHooks() = %+v, want %+v", got, tt.want)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("WithHooks() stdout hook = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("WithHooks() stderr hook = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+			if (done != nil) != tt.wantDone {
+				t.Fatalf("WithHooks() done = %v, wantDone %v", done, tt.wantDone)
+			}
+			if done != nil && done.ExitCode != tt.want.exitCode {
+				t.Errorf("WithHooks() done exit code = %d, want %d", done.ExitCode, tt.want.exitCode)
+			}
+		})
+	}
+}
+
+func TestWithHooks_osExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	e := Wrap(NewOsExec(), WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		return &Hooks{Stdout: stdout, Stderr: stderr}
+	}))
+
+	got, err := e.Command("sh", "-c", "echo out; echo err >&2").CombinedOutput()
+	if err != nil {
+		t.Fatalf("CombinedOutput() error = %v", err)
+	}
+	if lines := strings.Fields(string(got)); len(lines) != 2 {
+		t.Errorf("CombinedOutput() = %q, want out and err", got)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("CombinedOutput() hooks = %q, %q, want %q, %q", stdout, stderr, "out\n", "err\n")
+	}
+
+	_, err = e.Command("sh", "-c", "echo failed >&2; exit 4").Output()
+	ee, ok := err.(*exec.ExitError)
+	if !ok || ee.ExitCode() != 4 || string(ee.Stderr) != "failed\n" {
+		t.Errorf("Output() error = %#v, want exit code 4 with stderr", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"func cmd", &exitError{code: 7}, 7},
+		{"wrapped", fmt.Errorf("wrapped: %w", &exitError{code: 2}), 2},
+		{"other error", errors.New("boom"), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package puffin
+
+import "errors"
+
+// WithProcessGroup starts every command created by the OsExec in its own process group,
+// see OsCmd.SetProcessGroup
+func WithProcessGroup() OsExecOption {
+	return func(e *OsExec) {
+		e.processGroup = true
+	}
+}
+
+// SetProcessGroup sets whether the process is started in its own process group. When it is,
+// cancelling the context of the command or calling KillTree kills every process in the group,
+// so children the program started, like node started by npm, are killed too. Process groups
+// are only supported on unix, on other platforms only the process itself is killed
+func (c *OsCmd) SetProcessGroup(enabled bool) {
+	c.processGroup = enabled
+}
+
+// ProcessGroup reports whether the process is started in its own process group
+func (c *OsCmd) ProcessGroup() bool {
+	return c.processGroup
+}
+
+// KillTree kills the process and, if it was started in its own process group, every other
+// process in the group. It can be called after Wait to kill processes that are still running
+// after the program exited
+func (c *OsCmd) KillTree() error {
+	if c.Cmd.Process == nil {
+		return errors.New("puffin: not started")
+	}
+	if !c.processGroup {
+		return c.Cmd.Process.Kill()
+	}
+	return killGroup(c.Cmd.Process)
+}
+
+// watchContext kills the process group once the context of the command is done, exec.Cmd
+// only kills the process itself. The watch stops once the command has been waited on, Wait
+// kills the group itself if the context is done by then
+func (c *OsCmd) watchContext() {
+	if !c.processGroup || c.ctx == nil || c.ctx.Done() == nil {
+		return
+	}
+
+	ctx, done := c.ctx, make(chan struct{})
+	c.waitDone = done
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.KillTree()
+		case <-done:
+		}
+	}()
+}
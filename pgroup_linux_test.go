@@ -0,0 +1,207 @@
+package puffin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startTree starts a shell with a background sleep and returns the pid of the sleep
+func startTree(t *testing.T, cmd Cmd) int {
+	t.Helper()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() error = %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("reading pid: %v", err)
+	}
+
+	return pid
+}
+
+// running reports whether the process is running, zombies waiting to be reaped and processes
+// with a pending SIGKILL, that are being torn down, don't count
+func running(pid int) bool {
+	status, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch name {
+		case "State":
+			if strings.HasPrefix(value, "Z") || strings.HasPrefix(value, "X") {
+				return false
+			}
+		case "SigPnd", "ShdPnd":
+			pending, _ := strconv.ParseUint(value, 16, 64)
+			if pending&(1<<(syscall.SIGKILL-1)) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestOsCmd_SetProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := []struct {
+		name  string
+		group bool
+		stop  func(cmd *OsCmd, cancel context.CancelFunc)
+		want  bool
+	}{
+		{"cancel", true, func(_ *OsCmd, cancel context.CancelFunc) { cancel() }, false},
+		{"kill tree", true, func(cmd *OsCmd, _ context.CancelFunc) { cmd.KillTree() }, false},
+		{"no process group", false, func(_ *OsCmd, cancel context.CancelFunc) { cancel() }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cmd := NewOsExec().CommandContext(ctx, "sh", "-c", "sleep 30 & echo $!; wait").(*OsCmd)
+			cmd.SetProcessGroup(tt.group)
+			pid := startTree(t, cmd)
+			defer syscall.Kill(pid, syscall.SIGKILL)
+
+			tt.stop(cmd, cancel)
+			if err := cmd.Wait(); err == nil {
+				t.Errorf("Wait() error = nil, want the process to be killed")
+			}
+
+			waitFor(t, func() bool { return running(pid) == tt.want })
+		})
+	}
+}
+
+func TestOsCmd_KillTree_afterWait(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cmd := NewOsExec(WithProcessGroup()).Command("sh", "-c", "sleep 30 >/dev/null & echo $!").(*OsCmd)
+	pid := startTree(t, cmd)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if !running(pid) {
+		t.Fatalf("the background process exited early")
+	}
+
+	if err := cmd.KillTree(); err != nil {
+		t.Errorf("KillTree() error = %v", err)
+	}
+	waitFor(t, func() bool { return !running(pid) })
+}
+
+func TestOsCmd_KillTree_notStarted(t *testing.T) {
+	cmd := NewOsExec(WithProcessGroup()).Command("sh").(*OsCmd)
+	if err := cmd.KillTree(); err == nil {
+		t.Errorf("KillTree() error = nil, want an error")
+	}
+}
+
+func TestWithProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	oExec := NewOsExec(WithProcessGroup())
+	cmd := oExec.Command("sh", "-c", "ps -o pgid= -p $$; echo $$")
+	if !Clone(nil, oExec, cmd).(*OsCmd).ProcessGroup() {
+		t.Errorf("Clone() did not copy the process group setting")
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Skipf("ps is not available: %v", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 || fields[0] != fields[1] {
+		t.Errorf("process group of the shell = %q, want its own pid", out)
+	}
+	if cmd.SysProcAttr() != nil {
+		t.Errorf("SysProcAttr() = %+v, want it left unchanged", cmd.SysProcAttr())
+	}
+}
+
+func TestWithTimeouts_processGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	oExec := Wrap(NewOsExec(WithProcessGroup()), WithTimeouts(TimeoutPolicy{Default: 50 * time.Millisecond}))
+
+	start := time.Now()
+	_, err := oExec.Command("sh", "-c", "sleep 30 & wait").Output()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("Output() error = %v, want a *TimeoutError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("Output() took %v, the background process was not stopped", elapsed)
+	}
+}
+
+func TestOsCmd_Output_unmanaged(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// os/exec only keeps the start and end of the stderr of a failed command
+	_, err := NewOsExec().Command("sh", "-c", `i=0; while [ $i -lt 2000 ]; do echo 0123456789012345678901234567890123456789 >&2; i=$((i+1)); done; exit 1`).Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Output() error = %v, want an *exec.ExitError", err)
+	}
+	if len(exitErr.Stderr) >= 2000*41 {
+		t.Errorf("ExitError.Stderr has %d bytes, want the bounded capture of os/exec", len(exitErr.Stderr))
+	}
+}
+
+func TestOsCmd_Wait_canceledProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cmd := NewOsExec(WithProcessGroup()).CommandContext(ctx, "sh", "-c", "sleep 60 & echo $!; wait").(*OsCmd)
+		pid := startTree(t, cmd)
+		defer syscall.Kill(pid, syscall.SIGKILL)
+
+		cancel()
+		cmd.Wait()
+
+		// the group is killed by the time Wait returns, not some time after
+		if running(pid) {
+			t.Fatalf("the background process is still running after Wait() returned")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package puffin
+
+import (
+	"os"
+	"syscall"
+)
+
+// withProcessGroup returns attr unchanged since process groups are only supported on unix
+func withProcessGroup(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return attr
+}
+
+// killGroup kills only p since process groups are only supported on unix
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// signalGroup sends sig to p since process groups are only supported on unix
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package puffin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// withProcessGroup returns a copy of attr that starts the process in a new process group
+func withProcessGroup(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	copied := &syscall.SysProcAttr{}
+	if attr != nil {
+		*copied = *attr
+	}
+	copied.Setpgid = true
+	copied.Pgid = 0
+
+	return copied
+}
+
+// killGroup kills every process in the process group led by p
+func killGroup(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+// signalGroup sends sig to every process in the process group led by p
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("puffin: unsupported signal %v", sig)
+	}
+
+	err := syscall.Kill(-p.Pid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PolicyRule matches commands. Every field that is set must match for the rule to match
+type PolicyRule struct {
+	// Command is a path.Match pattern for the command. Patterns that contain a / are
+	// matched against the path of the command, e.g. /usr/bin/*, other patterns are
+	// matched against the base name of the command, e.g. git
+	Command string `json:"command"`
+
+	// Args are patterns that are matched against the arguments after the command, like the
+	// Args of a Fake. Each pattern is matched against a single argument using path.Match,
+	// except that * and ? also match /, a final pattern of "..." matches any remaining
+	// arguments. No patterns matches any arguments
+	Args []string `json:"args,omitempty"`
+
+	// Dirs are directories the working directory of the command must be in
+	Dirs []string `json:"dirs,omitempty"`
+
+	// Env lists environment variables that must be set for the rule to match.
+	// Entries are either NAME, or NAME=pattern to also match the value, * and ? also match /
+	Env []string `json:"env,omitempty"`
+}
+
+// String returns the rule as a command line
+func (r PolicyRule) String() string {
+	return strings.Join(append([]string{r.Command}, r.Args...), " ")
+}
+
+// match checks if the rule matches the command
+func (r PolicyRule) match(cmd Cmd, dir string, env map[string]string) bool {
+	if !matchCommand(r.Command, cmd) {
+		return false
+	}
+
+	if len(r.Args) > 0 {
+		args := cmd.Args()
+		if len(args) > 0 {
+			args = args[1:]
+		}
+		if _, ok := matchArgs(slashless(r.Args), slashless(args)); !ok {
+			return false
+		}
+	}
+
+	if len(r.Dirs) > 0 && !inDirs(dir, r.Dirs) {
+		return false
+	}
+
+	return matchEnv(slashless(r.Env), env)
+}
+
+// slashless replaces / with a NUL byte in every string so * and ? in path.Match patterns
+// also match /. Arguments and env vars can not contain NUL bytes so this never creates a
+// false match, and it stops a deny rule like --upload-pack* being bypassed with a path
+func slashless(list []string) []string {
+	replaced := make([]string, len(list))
+	for i, s := range list {
+		replaced[i] = strings.ReplaceAll(s, "/", "\x00")
+	}
+	return replaced
+}
+
+// validate checks that the rules patterns are valid
+func (r PolicyRule) validate() error {
+	if r.Command == "" {
+		return fmt.Errorf("policy rule %q: missing command", r)
+	}
+
+	patterns := append([]string{r.Command}, r.Args...)
+	for _, e := range r.Env {
+		_, pattern, _ := strings.Cut(e, "=")
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("policy rule %q: %w", r, err)
+		}
+	}
+
+	return nil
+}
+
+// matchCommand checks if pattern matches the path or name of the command. Only the path is
+// used, Args()[0] can be set to anything so matching it would let any program pass the rule
+func matchCommand(pattern string, cmd Cmd) bool {
+	name := cmd.Path()
+	if !strings.Contains(pattern, "/") {
+		name = filepath.Base(name)
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// inDirs checks if dir is one of dirs or inside one of them
+func inDirs(dir string, dirs []string) bool {
+	for _, parent := range dirs {
+		parent = filepath.Clean(parent)
+		if dir == parent || strings.HasPrefix(dir, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy decides which commands are allowed to run. A command is denied if it matches any
+// of the Deny rules. Otherwise, if there are Allow rules, it must match at least one of them
+type Policy struct {
+	Allow []PolicyRule `json:"allow"`
+	Deny  []PolicyRule `json:"deny"`
+}
+
+// LoadPolicy loads a policy from a json file, see ParsePolicy
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy parses a policy from json. Unknown fields are an error so a typo can not
+// silently weaken the policy. For example
+//
+//	{
+//	  "allow": [
+//	    {"command": "git", "args": ["fetch", "..."], "dirs": ["/srv/repos"]},
+//	    {"command": "/usr/bin/convert"}
+//	  ],
+//	  "deny": [
+//	    {"command": "*", "env": ["LD_PRELOAD"]}
+//	  ]
+//	}
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	p := &Policy{}
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+
+	for _, rules := range [][]PolicyRule{p.Allow, p.Deny} {
+		for _, rule := range rules {
+			if err := rule.validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// PolicyError is the reason a command was denied by a Policy. Errors returned by Check
+// are an *exec.Error that wraps a PolicyError, and match os.ErrPermission with errors.Is
+type PolicyError struct {
+	// Rule is the deny rule that matched the command, or nil if no allow rule matched
+	Rule *PolicyRule
+}
+
+func (e *PolicyError) Error() string {
+	if e.Rule == nil {
+		return "permission denied by policy: no allow rule matches"
+	}
+	return fmt.Sprintf("permission denied by policy: deny rule %q matches", e.Rule.String())
+}
+
+func (e *PolicyError) Unwrap() error {
+	return os.ErrPermission
+}
+
+// Check returns an error if the policy does not allow the command
+func (p *Policy) Check(cmd Cmd) error {
+	dir := cmd.Dir()
+	if !filepath.IsAbs(dir) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	dir = filepath.Clean(dir)
+
+	env := map[string]string{}
+	for _, kv := range slashless(cmd.Environ()) {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+
+	for i := range p.Deny {
+		if p.Deny[i].match(cmd, dir, env) {
+			return &exec.Error{Name: cmd.Path(), Err: &PolicyError{Rule: &p.Deny[i]}}
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, rule := range p.Allow {
+		if rule.match(cmd, dir, env) {
+			return nil
+		}
+	}
+
+	return &exec.Error{Name: cmd.Path(), Err: &PolicyError{}}
+}
+
+// WithPolicy returns a Middleware that checks every command against the policy before
+// it is started. Commands that are denied are never started and Start returns the
+// error from Policy.Check
+func WithPolicy(p *Policy) Middleware {
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		return &Hooks{
+			BeforeStart: func() error {
+				return p.Check(cmd)
+			},
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package puffin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := LoadPolicy("testdata/policy.json")
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	fExec := NewFuncExec(WithFuncMap(map[string]CmdFunc{
+		"/usr/bin/git":     Respond("", "", 0),
+		"/usr/bin/convert": Respond("", "", 0),
+		"/usr/bin/rm":      Respond("", "", 0),
+	}))
+
+	tests := []struct {
+		name     string
+		args     []string
+		dir      string
+		env      []string
+		wantErr  bool
+		wantRule string
+	}{
+		{"allowed", []string{"git", "fetch", "origin"}, "/srv/repos/app", nil, false, ""},
+		{"allowed by path", []string{"convert", "in.png", "out.jpg"}, "", nil, false, ""},
+		{"exact args", []string{"git", "status"}, "", nil, false, ""},
+		{"extra args", []string{"git", "status", "--short"}, "", nil, true, ""},
+		{"outside dir", []string{"git", "fetch"}, "/srv/reposx", nil, true, ""},
+		{"not allowed", []string{"rm", "-rf", "/"}, "", nil, true, ""},
+		{"denied args", []string{"git", "fetch", "--upload-pack=touch /tmp/x", "origin"}, "/srv/repos", nil, true, "git fetch --upload-pack* ..."},
+		{"denied env", []string{"convert", "a", "b"}, "", []string{"LD_PRELOAD=/tmp/x.so"}, true, "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := fExec.Command(tt.args[0], tt.args[1:]...)
+			cmd.SetDir(tt.dir)
+			if tt.env != nil {
+				cmd.SetEnv(tt.env)
+			}
+
+			err := policy.Check(cmd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Policy.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+
+			var execErr *exec.Error
+			var policyErr *PolicyError
+			if !errors.As(err, &execErr) || !errors.As(err, &policyErr) || !errors.Is(err, os.ErrPermission) {
+				t.Fatalf("Policy.Check() error = %#v, want an exec.Error wrapping a PolicyError", err)
+			}
+			if tt.wantRule != "" && (policyErr.Rule == nil || policyErr.Rule.String() != tt.wantRule) {
+				t.Errorf("Policy.Check() rule = %v, want %q", policyErr.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestPolicy_Check_argv0(t *testing.T) {
+	policy, err := LoadPolicy("testdata/policy.json")
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	fExec := NewFuncExec(WithFuncMap(map[string]CmdFunc{
+		"/usr/bin/rm": Respond("", "", 0),
+	}))
+
+	// the command runs rm, so an allow rule for git must not match it
+	cmd := fExec.Command("rm", "fetch", "origin")
+	cmd.SetArgs([]string{"git", "fetch", "origin"})
+	cmd.SetDir("/srv/repos/app")
+	if err := policy.Check(cmd); err == nil {
+		t.Errorf("Policy.Check() error = nil, want rm with argv[0] git to be denied")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", `{"allow": [{"command": "ls"}]}`, false},
+		{"empty", `{}`, false},
+		{"unknown field", `{"allow": [{"command": "ls", "arg": ["-l"]}]}`, true},
+		{"missing command", `{"deny": [{"args": ["x"]}]}`, true},
+		{"bad pattern", `{"deny": [{"command": "[x"}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	ran := false
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"curl": func(fc *FuncCmd) int {
+				ran = true
+				return 0
+			},
+		})),
+		WithPolicy(&Policy{Allow: []PolicyRule{{Command: "git"}}}),
+	)
+
+	err := exec.Command("curl", "https://example.com").Run()
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Run() error = %v, want a permission error", err)
+	}
+	if ran {
+		t.Errorf("Run() started a command that was denied")
+	}
+}
@@ -0,0 +1,109 @@
+package puffin
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Respond returns a CmdFunc that writes stdout and stderr and then exits with the given code
+func Respond(stdout, stderr string, code int) CmdFunc {
+	return func(fc *FuncCmd) int {
+		io.WriteString(orDiscard(fc.Stdout()), stdout)
+		io.WriteString(orDiscard(fc.Stderr()), stderr)
+		return code
+	}
+}
+
+// Sequence returns a CmdFunc that runs the nth function on the nth call.
+// Once every function has been used, the last function is used for all later calls.
+// Sequence is safe to use from multiple commands running at the same time
+func Sequence(fns ...CmdFunc) CmdFunc {
+	if len(fns) == 0 {
+		panic("puffin: Sequence requires at least one CmdFunc")
+	}
+
+	var mu sync.Mutex
+	calls := 0
+	return func(fc *FuncCmd) int {
+		mu.Lock()
+		fn := fns[len(fns)-1]
+		if calls < len(fns) {
+			fn = fns[calls]
+		}
+		calls++
+		mu.Unlock()
+
+		return fn(fc)
+	}
+}
+
+// FailTimes returns a CmdFunc that fails with exit code 1 for the first n calls
+// and runs then for every call after that. A negative n is treated as 0
+func FailTimes(n int, then CmdFunc) CmdFunc {
+	if n < 0 {
+		n = 0
+	}
+
+	fail := func(fc *FuncCmd) int {
+		fmt.Fprintf(orDiscard(fc.Stderr()), "%s: failed\n", fc.Args()[0])
+		return 1
+	}
+
+	fns := make([]CmdFunc, 0, n+1)
+	for i := 0; i < n; i++ {
+		fns = append(fns, fail)
+	}
+
+	return Sequence(append(fns, then)...)
+}
+
+// Delay returns a CmdFunc that waits for d before running fn.
+// If the command is canceled while waiting, fn is never run
+func Delay(d time.Duration, fn CmdFunc) CmdFunc {
+	return func(fc *FuncCmd) int {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return fn(fc)
+		case <-fc.Context().Done():
+			return -1
+		}
+	}
+}
+
+// StreamLines returns a CmdFunc that writes each line to stdout, waiting for interval
+// between each line. Streaming stops early if the command is canceled
+func StreamLines(lines []string, interval time.Duration) CmdFunc {
+	return func(fc *FuncCmd) int {
+		stdout := orDiscard(fc.Stdout())
+		for i, line := range lines {
+			if i > 0 {
+				timer := time.NewTimer(interval)
+				select {
+				case <-timer.C:
+				case <-fc.Context().Done():
+					timer.Stop()
+					return -1
+				}
+			}
+
+			if _, err := io.WriteString(stdout, line+"\n"); err != nil {
+				return 1
+			}
+		}
+
+		return 0
+	}
+}
+
+// orDiscard returns w, or io.Discard if w is nil
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
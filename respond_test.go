@@ -0,0 +1,163 @@
+package puffin
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runFunc runs fn as the "test" command and returns its stdout, stderr and error
+func runFunc(ctx context.Context, fn CmdFunc) (string, string, error) {
+	exec := NewFuncExec(WithFuncMap(map[string]CmdFunc{"test": fn}))
+	cmd := exec.CommandContext(ctx, "test")
+
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	cmd.SetStdout(stdout)
+	cmd.SetStderr(stderr)
+	err := cmd.Run()
+
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		name       string
+		fn         CmdFunc
+		wantStdout string
+		wantStderr string
+		wantErr    bool
+	}{
+		{"stdout", Respond("out", "", 0), "out", "", false},
+		{"stderr with exit code", Respond("", "err", 2), "", "err", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, err := runFunc(context.Background(), tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Respond() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stdout != tt.wantStdout || stderr != tt.wantStderr {
+				t.Errorf("Respond() = %q, %q, want %q, %q", stdout, stderr, tt.wantStdout, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	fn := Sequence(
+		Respond("first", "", 0),
+		Respond("second", "", 1),
+		Respond("last", "", 0),
+	)
+
+	want := []string{"first", "second", "last", "last"}
+	for i, w := range want {
+		got, _, _ := runFunc(context.Background(), fn)
+		if got != w {
+			t.Errorf("Sequence() call %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestSequence_concurrent(t *testing.T) {
+	var fns []CmdFunc
+	var want []string
+	for i := 0; i < 50; i++ {
+		out := string(rune('a'+i%26)) + strings.Repeat("!", i/26)
+		fns = append(fns, Respond(out, "", 0))
+		want = append(want, out)
+	}
+	fn := Sequence(fns...)
+
+	var mu sync.Mutex
+	var got []string
+	var wg sync.WaitGroup
+	for i := 0; i < len(fns); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, _, _ := runFunc(context.Background(), fn)
+			mu.Lock()
+			got = append(got, out)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Sequence() each function was not called exactly once, got %v", got)
+	}
+}
+
+func TestFailTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr []bool
+	}{
+		{"fails twice", 2, []bool{true, true, false, false}},
+		{"never fails", 0, []bool{false, false}},
+		{"negative", -1, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := FailTimes(tt.n, Respond("ok", "", 0))
+			for i, w := range tt.wantErr {
+				_, _, err := runFunc(context.Background(), fn)
+				if (err != nil) != w {
+					t.Errorf("FailTimes() call %d error = %v, wantErr %v", i, err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		delay      time.Duration
+		wantStdout string
+		wantErr    bool
+	}{
+		{"delayed", time.Minute, time.Millisecond, "done", false},
+		{"canceled", time.Millisecond, time.Minute, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			start := time.Now()
+			stdout, _, err := runFunc(ctx, Delay(tt.delay, Respond("done", "", 0)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Delay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("Delay() = %q, want %q", stdout, tt.wantStdout)
+			}
+			if elapsed := time.Since(start); elapsed < tt.delay && elapsed < tt.timeout {
+				t.Errorf("Delay() returned after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestStreamLines(t *testing.T) {
+	exec := NewFuncExec(WithFuncMap(map[string]CmdFunc{
+		"test": StreamLines([]string{"one", "two", "three"}, time.Millisecond),
+	}))
+
+	got, err := exec.Command("test").Output()
+	if err != nil {
+		t.Fatalf("StreamLines() error = %v", err)
+	}
+	if string(got) != "one\ntwo\nthree\n" {
+		t.Errorf("StreamLines() = %q, want %q", got, "one\ntwo\nthree\n")
+	}
+}
@@ -0,0 +1,213 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RetryPolicy configures when and how often a command is retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the command is run, the default is 3
+	MaxAttempts int
+
+	// ExitCodes are the exit codes that are retried, see ExitCode
+	ExitCodes []int
+
+	// StderrPatterns are matched against the standard error of a failed attempt,
+	// the command is retried if any of them match
+	StderrPatterns []*regexp.Regexp
+
+	// InitialBackoff is how long to wait before the first retry, the default is 100ms
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the longest time to wait between attempts, the default is 10s
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the backoff grows by after each retry, the default is 2
+	Multiplier float64
+
+	// Jitter randomizes each backoff by up to this fraction of its value, e.g. 0.2 for ±20%.
+	// It's limited to between 0 and 1 so a backoff is never negative
+	Jitter float64
+
+	// OnRetry, if not nil, is called before waiting to retry a failed attempt
+	OnRetry func(attempt int, err error, backoff time.Duration)
+}
+
+// retryable reports whether a failed attempt should be retried. If no exit codes or
+// stderr patterns are set, every failed attempt is retried
+func (p *RetryPolicy) retryable(err error, stderr []byte) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.ExitCodes) == 0 && len(p.StderrPatterns) == 0 {
+		return true
+	}
+
+	code := ExitCode(err)
+	for _, c := range p.ExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	for _, pattern := range p.StderrPatterns {
+		if pattern.Match(stderr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns how long to wait after the given attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// WithRetry returns a Middleware that runs commands again when they fail in a way the policy
+// considers transient. Every attempt runs a Clone of the command and the standard input
+// is buffered so it can be replayed for each attempt. The output of each attempt is
+// buffered as well and only the output of the final attempt is written to the command,
+// so output is not streamed while the command runs. Waiting between attempts stops early
+// if the commands context is canceled
+func WithRetry(policy RetryPolicy) Middleware {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 10 * time.Second
+	}
+	if policy.Multiplier <= 0 {
+		policy.Multiplier = 2
+	}
+	policy.Jitter = math.Max(0, math.Min(policy.Jitter, 1))
+
+	return func(base Exec) Exec {
+		return &retryExec{base: base, policy: &policy}
+	}
+}
+
+// retryExec is an Exec that retries the commands created by the base Exec
+type retryExec struct {
+	base   Exec
+	policy *RetryPolicy
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *retryExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that is retried when it fails
+func (e *retryExec) Command(name string, arg ...string) Cmd {
+	return newShimCmd(nil, e.base.Command(name, arg...), e.run)
+}
+
+// CommandContext creates a Cmd that is retried when it fails
+func (e *retryExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return newShimCmd(ctx, e.base.CommandContext(ctx, name, arg...), e.run)
+}
+
+// run runs attempts of the command until one succeeds, a failure is not retryable,
+// or the maximum number of attempts is reached
+func (e *retryExec) run(s *shimCmd, stdin io.Reader, stdout, stderr io.Writer) error {
+	replay := &replayReader{src: stdin}
+
+	// when the caller uses the same writer for both, the attempts share a buffer as well
+	// so the output keeps its order
+	shared := sameWriter(stdout, stderr)
+
+	var err error
+	var outBuf, errBuf bytes.Buffer
+	for attempt := 1; ; attempt++ {
+		outBuf.Reset()
+		errBuf.Reset()
+		attemptOut, attemptErr := io.Writer(&outBuf), io.Writer(&errBuf)
+		if shared {
+			sw := &syncWriter{w: &outBuf}
+			attemptOut, attemptErr = sw, sw
+		}
+
+		err = s.runClone(s.ctx, e.base, replay.replay(), attemptOut, attemptErr)
+
+		attemptStderr := errBuf.Bytes()
+		if shared {
+			attemptStderr = outBuf.Bytes()
+		}
+		if attempt >= e.policy.MaxAttempts || !e.policy.retryable(err, attemptStderr) {
+			break
+		}
+
+		backoff := e.policy.backoff(attempt)
+		if e.policy.OnRetry != nil {
+			e.policy.OnRetry(attempt, err, backoff)
+		}
+		if sleepErr := s.sleep(backoff); sleepErr != nil {
+			// a stopped command keeps the error of its last attempt
+			if !errors.Is(sleepErr, errStopped) {
+				err = sleepErr
+			}
+			break
+		}
+	}
+
+	stdout.Write(outBuf.Bytes())
+	if !shared {
+		stderr.Write(errBuf.Bytes())
+	}
+
+	return err
+}
+
+// replayReader records everything read from src so it can be read again
+type replayReader struct {
+	mu  sync.Mutex
+	src io.Reader
+	buf bytes.Buffer
+}
+
+// replay returns a reader that reads everything that has been read from src so far,
+// and then continues reading from src
+func (r *replayReader) replay() io.Reader {
+	r.mu.Lock()
+	recorded := append([]byte(nil), r.buf.Bytes()...)
+	r.mu.Unlock()
+
+	return io.MultiReader(bytes.NewReader(recorded), readerFunc(r.read))
+}
+
+// read reads from src and records what was read
+func (r *replayReader) read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+
+	r.mu.Lock()
+	r.buf.Write(p[:n])
+	r.mu.Unlock()
+
+	return n, err
+}
+
+// readerFunc is an io.Reader implemented by a function
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
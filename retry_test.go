@@ -0,0 +1,241 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		fn           CmdFunc
+		wantOut      string
+		wantCode     int
+		wantAttempts int
+	}{
+		{
+			"succeeds after retries",
+			RetryPolicy{},
+			FailTimes(2, Respond("ok\n", "", 0)),
+			"ok\n",
+			0,
+			3,
+		},
+		{
+			"gives up after max attempts",
+			RetryPolicy{MaxAttempts: 2},
+			FailTimes(5, Respond("ok\n", "", 0)),
+			"",
+			1,
+			2,
+		},
+		{
+			"exit code not retried",
+			RetryPolicy{ExitCodes: []int{75}},
+			Sequence(Respond("", "", 1), Respond("ok\n", "", 0)),
+			"",
+			1,
+			1,
+		},
+		{
+			"exit code retried",
+			RetryPolicy{ExitCodes: []int{75}},
+			Sequence(Respond("partial", "", 75), Respond("ok\n", "", 0)),
+			"ok\n",
+			0,
+			2,
+		},
+		{
+			"stderr pattern retried",
+			RetryPolicy{StderrPatterns: []*regexp.Regexp{regexp.MustCompile(`(?i)connection reset`)}},
+			Sequence(Respond("", "Connection reset by peer\n", 1), Respond("ok\n", "", 0)),
+			"ok\n",
+			0,
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			fn := tt.fn
+			tt.policy.InitialBackoff = time.Millisecond
+			tt.policy.Jitter = 0.5
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{
+					"flaky": func(fc *FuncCmd) int {
+						attempts++
+						return fn(fc)
+					},
+				})),
+				WithRetry(tt.policy),
+			)
+
+			out, err := exec.Command("flaky").Output()
+			if string(out) != tt.wantOut || ExitCode(err) != tt.wantCode {
+				t.Errorf("Output() = %q, %v, want %q with exit code %d", out, err, tt.wantOut, tt.wantCode)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("WithRetry() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWithRetry_stdin(t *testing.T) {
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"apply": Sequence(
+				func(fc *FuncCmd) int {
+					// read part of the input before failing
+					io.ReadFull(fc.Stdin(), make([]byte, 3))
+					return 1
+				},
+				func(fc *FuncCmd) int {
+					data, _ := io.ReadAll(fc.Stdin())
+					fc.Stdout().Write(data)
+					return 0
+				},
+			),
+		})),
+		WithRetry(RetryPolicy{InitialBackoff: time.Millisecond}),
+	)
+
+	cmd := exec.Command("apply")
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	io.WriteString(stdin, "kind: Deployment")
+	stdin.Close()
+
+	out, _ := io.ReadAll(stdout)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if string(out) != "kind: Deployment" {
+		t.Errorf("StdoutPipe() = %q, want the full input to be replayed", out)
+	}
+}
+
+func TestWithRetry_context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"fail": Respond("", "", 1),
+		})),
+		WithRetry(RetryPolicy{
+			MaxAttempts:    10,
+			InitialBackoff: time.Hour,
+			OnRetry: func(attempt int, err error, backoff time.Duration) {
+				cancel()
+			},
+		}),
+	)
+
+	done := make(chan error)
+	go func() {
+		done <- exec.CommandContext(ctx, "fail").Run()
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not stop waiting when the context was canceled")
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	want := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("RetryPolicy.backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("RetryPolicy.backoff() with jitter = %v, want between 50ms and 150ms", got)
+		}
+	}
+}
+
+func TestWithRetry_jitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter float64
+		want   float64
+	}{
+		{"in range", 0.2, 0.2},
+		{"too large", 5, 1},
+		{"negative", -0.5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rExec := Wrap(NewFuncExec(), WithRetry(RetryPolicy{Jitter: tt.jitter})).(*retryExec)
+			if rExec.policy.Jitter != tt.want {
+				t.Errorf("WithRetry() jitter = %v, want %v", rExec.policy.Jitter, tt.want)
+			}
+			for i := 0; i < 100; i++ {
+				if got := rExec.policy.backoff(1); got < 0 {
+					t.Fatalf("RetryPolicy.backoff() = %v, want a backoff that is not negative", got)
+				}
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	fExec := NewFuncExec(WithFuncMap(map[string]CmdFunc{"go": Respond("", "", 0)}))
+
+	cmd := fExec.Command("go", "build", "./...")
+	cmd.SetDir("/src")
+	cmd.SetEnv([]string{"GOOS=linux"})
+	cmd.SetStdout(&strings.Builder{})
+	cmd.Run()
+
+	clone := Clone(context.Background(), fExec, cmd)
+	if strings.Join(clone.Args(), " ") != "go build ./..." || clone.Dir() != "/src" || strings.Join(clone.Env(), ",") != "GOOS=linux" {
+		t.Errorf("Clone() = %v in %s with %v", clone.Args(), clone.Dir(), clone.Env())
+	}
+	if clone.Stdout() != nil || clone.Process() != nil {
+		t.Errorf("Clone() copied the io or process state of cmd")
+	}
+	if err := clone.Run(); err != nil {
+		t.Errorf("Clone().Run() error = %v", err)
+	}
+}
+
+func TestWithRetry_osExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	counter := filepath.Join(t.TempDir(), "attempts")
+	script := `n=$(cat "$1" 2>/dev/null || echo 0); n=$((n+1)); echo $n > "$1"; if [ $n -lt 2 ]; then echo busy >&2; exit 3; fi; cat`
+	e := Wrap(NewOsExec(), WithRetry(RetryPolicy{ExitCodes: []int{3}, InitialBackoff: time.Millisecond}))
+
+	cmd := e.Command("sh", "-c", script, "sh", counter)
+	cmd.SetStdin(strings.NewReader("replayed"))
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "replayed" {
+		t.Errorf("CombinedOutput() = %q, %v, want the replayed stdin", out, err)
+	}
+	if cmd.ProcessState() == nil || !cmd.ProcessState().Success() {
+		t.Errorf("ProcessState() = %v, want the successful attempt", cmd.ProcessState())
+	}
+}
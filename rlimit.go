@@ -0,0 +1,122 @@
+package puffin
+
+import (
+	"fmt"
+	"time"
+)
+
+// RlimitResource is a resource that can be limited with an Rlimit
+type RlimitResource int
+
+const (
+	// RlimitCPU limits the CPU time of the process in seconds
+	RlimitCPU RlimitResource = iota + 1
+
+	// RlimitAddressSpace limits the size of the virtual memory of the process in bytes
+	RlimitAddressSpace
+
+	// RlimitOpenFiles limits the number of files the process can have open
+	RlimitOpenFiles
+
+	// RlimitProcesses limits the number of processes the user running the process can have,
+	// it counts every process of the user, not only the children of the process
+	RlimitProcesses
+
+	// RlimitCoreSize limits the size of the core dump of the process in bytes, 0 disables them
+	RlimitCoreSize
+)
+
+func (r RlimitResource) String() string {
+	switch r {
+	case RlimitCPU:
+		return "cpu time"
+	case RlimitAddressSpace:
+		return "address space"
+	case RlimitOpenFiles:
+		return "open files"
+	case RlimitProcesses:
+		return "processes"
+	case RlimitCoreSize:
+		return "core size"
+	default:
+		return fmt.Sprintf("RlimitResource(%d)", int(r))
+	}
+}
+
+// Rlimit is a resource limit of a process. Soft is the limit the process is held to,
+// Hard is the ceiling the process can raise its soft limit to
+type Rlimit struct {
+	Resource RlimitResource
+	Soft     uint64
+	Hard     uint64
+}
+
+// CPULimit limits the CPU time of the process. The process is sent SIGXCPU once it has used d,
+// and is killed a second later if it's still running
+func CPULimit(d time.Duration) Rlimit {
+	seconds := uint64((d + time.Second - 1) / time.Second)
+	return Rlimit{Resource: RlimitCPU, Soft: seconds, Hard: seconds + 1}
+}
+
+// AddressSpaceLimit limits the virtual memory of the process to the given number of bytes
+func AddressSpaceLimit(bytes uint64) Rlimit {
+	return Rlimit{Resource: RlimitAddressSpace, Soft: bytes, Hard: bytes}
+}
+
+// OpenFilesLimit limits the number of files the process can have open
+func OpenFilesLimit(n uint64) Rlimit {
+	return Rlimit{Resource: RlimitOpenFiles, Soft: n, Hard: n}
+}
+
+// ProcessLimit limits the number of processes of the user running the process
+func ProcessLimit(n uint64) Rlimit {
+	return Rlimit{Resource: RlimitProcesses, Soft: n, Hard: n}
+}
+
+// CoreSizeLimit limits the size of the core dumps of the process, 0 disables them
+func CoreSizeLimit(bytes uint64) Rlimit {
+	return Rlimit{Resource: RlimitCoreSize, Soft: bytes, Hard: bytes}
+}
+
+// RlimitError is returned by Wait when a process was killed because it went over its CPU limit.
+// Other limits make system calls fail rather than terminating the process
+type RlimitError struct {
+	Limit Rlimit
+
+	// Err is the error the process exited with
+	Err error
+}
+
+func (e *RlimitError) Error() string {
+	return fmt.Sprintf("exceeded %s limit of %d: %v", e.Limit.Resource, e.Limit.Soft, e.Err)
+}
+
+func (e *RlimitError) Unwrap() error {
+	return e.Err
+}
+
+// OsExecOption configures an OsExec
+type OsExecOption func(*OsExec)
+
+// WithRlimits applies resource limits to every command created by the OsExec,
+// see OsCmd.SetRlimits
+func WithRlimits(limits ...Rlimit) OsExecOption {
+	return func(e *OsExec) {
+		e.rlimits = append(e.rlimits, limits...)
+	}
+}
+
+// SetRlimits sets resource limits that are applied to the process after it's created and
+// before the program starts running. Limits are only supported on linux, on other platforms
+// Start returns an error if any limits are set. The limits are applied by briefly tracing the
+// process with ptrace (PTRACE_TRACEME), so Start fails where tracing is blocked, e.g. by a seccomp
+// filter, yama with ptrace_scope set to 3 or in some containers. They can't be combined with
+// SysProcAttr.Ptrace
+func (c *OsCmd) SetRlimits(limits ...Rlimit) {
+	c.rlimits = append([]Rlimit(nil), limits...)
+}
+
+// Rlimits returns the resource limits of the command
+func (c *OsCmd) Rlimits() []Rlimit {
+	return append([]Rlimit(nil), c.rlimits...)
+}
@@ -0,0 +1,121 @@
+package puffin
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// rlimitResources maps resources to their linux values
+var rlimitResources = map[RlimitResource]int{
+	RlimitCPU:          syscall.RLIMIT_CPU,
+	RlimitAddressSpace: syscall.RLIMIT_AS,
+	RlimitOpenFiles:    syscall.RLIMIT_NOFILE,
+	RlimitProcesses:    rlimitNPROC,
+	RlimitCoreSize:     syscall.RLIMIT_CORE,
+}
+
+// startLimited starts the process traced so it stops right after exec, before the program runs.
+// The limits are set with prlimit while it's stopped and then the process is released
+func (c *OsCmd) startLimited() error {
+	orig := c.Cmd.SysProcAttr
+	attr := &syscall.SysProcAttr{}
+	if orig != nil {
+		if orig.Ptrace {
+			return errors.New("puffin: resource limits can not be used with SysProcAttr.Ptrace")
+		}
+		*attr = *orig
+	}
+	attr.Ptrace = true
+
+	// only the thread that started a traced process can release it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	c.Cmd.SysProcAttr = attr
+	err := c.Cmd.Start()
+	c.Cmd.SysProcAttr = orig
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSYS) {
+		return fmt.Errorf("puffin: resource limits are applied with ptrace, which may not be allowed: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// a traced process that is not released stays stopped, so it's killed on any error
+	pid := c.Cmd.Process.Pid
+	if err := applyRlimits(pid, c.rlimits); err != nil {
+		c.Cmd.Process.Kill()
+		syscall.PtraceDetach(pid)
+		c.Cmd.Wait()
+		return err
+	}
+	if err := syscall.PtraceDetach(pid); err != nil {
+		c.Cmd.Process.Kill()
+		c.Cmd.Wait()
+		return fmt.Errorf("puffin: releasing process %d after applying resource limits: %w", pid, err)
+	}
+
+	return nil
+}
+
+// applyRlimits waits for the traced process to stop and sets its resource limits
+func applyRlimits(pid int, limits []Rlimit) error {
+	var status syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err == nil {
+			break
+		}
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+	// a seccomp filter can kill the process when it asks to be traced
+	if !status.Stopped() {
+		return fmt.Errorf("puffin: process %d did not stop to apply resource limits, ptrace may not be allowed", pid)
+	}
+
+	for _, limit := range limits {
+		resource, ok := rlimitResources[limit.Resource]
+		if !ok {
+			return fmt.Errorf("puffin: unknown resource limit %v", limit.Resource)
+		}
+
+		rlimit := syscall.Rlimit{Cur: limit.Soft, Max: limit.Hard}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("puffin: setting %s limit: %w", limit.Resource, errno)
+		}
+	}
+
+	return nil
+}
+
+// limitError returns an *RlimitError if the process was terminated for going over its CPU limit.
+// The process is sent SIGXCPU at the soft limit and SIGKILL at the hard limit
+func (c *OsCmd) limitError(err error) error {
+	state := c.Cmd.ProcessState
+	if state == nil {
+		return err
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return err
+	}
+
+	for _, limit := range c.rlimits {
+		if limit.Resource != RlimitCPU {
+			continue
+		}
+
+		cpu := uint64((state.UserTime() + state.SystemTime()).Seconds())
+		if status.Signal() == syscall.SIGXCPU || (status.Signal() == syscall.SIGKILL && cpu >= limit.Hard) {
+			return &RlimitError{Limit: limit, Err: err}
+		}
+	}
+
+	return err
+}
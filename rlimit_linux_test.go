@@ -0,0 +1,76 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestOsCmd_SetRlimits(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := []struct {
+		name   string
+		limits []Rlimit
+		script string
+		want   string
+	}{
+		{"open files", []Rlimit{OpenFilesLimit(64)}, "ulimit -n", "64\n"},
+		{"core size", []Rlimit{CoreSizeLimit(0)}, "ulimit -c", "0\n"},
+		{"cpu time", []Rlimit{CPULimit(30e9)}, "ulimit -t", "30\n"},
+		{"several", []Rlimit{OpenFilesLimit(32), CoreSizeLimit(0)}, "ulimit -n; ulimit -c", "32\n0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := NewOsExec(WithRlimits(tt.limits...)).Command("sh", "-c", tt.script).Output()
+			if err != nil {
+				t.Fatalf("Output() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Output() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestOsCmd_SetRlimits_cpu(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cmd := NewOsExec().CommandContext(context.Background(), "sh", "-c", "while :; do :; done")
+	cmd.(*OsCmd).SetRlimits(CPULimit(1e9))
+
+	err := cmd.Run()
+	var limitErr *RlimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit.Resource != RlimitCPU {
+		t.Fatalf("Run() error = %v, want an *RlimitError for the cpu time", err)
+	}
+	if ExitCode(err) != -1 {
+		t.Errorf("ExitCode() = %d, want -1", ExitCode(err))
+	}
+}
+
+func TestOsCmd_SetRlimits_ptrace(t *testing.T) {
+	cmd := NewOsExec(WithRlimits(OpenFilesLimit(64))).Command("true").(*OsCmd)
+	cmd.SetSysProcAttr(&syscall.SysProcAttr{Ptrace: true})
+
+	if err := cmd.Start(); err == nil {
+		t.Error("Start() with SysProcAttr.Ptrace succeeded, want an error")
+	}
+}
+
+func TestClone_rlimits(t *testing.T) {
+	oExec := NewOsExec()
+	cmd := oExec.Command("true")
+	cmd.(*OsCmd).SetRlimits(OpenFilesLimit(64))
+
+	clone := Clone(nil, oExec, cmd)
+	if limits := clone.(*OsCmd).Rlimits(); len(limits) != 1 || limits[0] != OpenFilesLimit(64) {
+		t.Errorf("Clone() rlimits = %v, want the limits of cmd", limits)
+	}
+}
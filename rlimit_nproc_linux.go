@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package puffin
+
+// rlimitNPROC is RLIMIT_NPROC, which the syscall package doesn't define
+const rlimitNPROC = 0x6
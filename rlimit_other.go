@@ -0,0 +1,18 @@
+//go:build !linux
+
+package puffin
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// startLimited returns an error since resource limits are only supported on linux
+func (c *OsCmd) startLimited() error {
+	return fmt.Errorf("puffin: resource limits are not supported on %s", runtime.GOOS)
+}
+
+// limitError returns err unchanged since resource limits are only supported on linux
+func (c *OsCmd) limitError(err error) error {
+	return err
+}
@@ -0,0 +1,23 @@
+package puffin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCPULimit(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		wantSoft uint64
+	}{
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, tt := range tests {
+		got := CPULimit(tt.d)
+		if got.Resource != RlimitCPU || got.Soft != tt.wantSoft || got.Hard != tt.wantSoft+1 {
+			t.Errorf("CPULimit(%v) = %+v, want soft limit %d", tt.d, got, tt.wantSoft)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package puffin
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+)
+
+// ScopeOption configures the defaults of a Scope
+type ScopeOption func(*scopeExec)
+
+// WithDir sets the working directory of every command. A relative dir is relative to the
+// dir set by an enclosing Scope, so scopes can be nested, e.g. a repo and then a sub module
+func WithDir(dir string) ScopeOption {
+	return func(e *scopeExec) {
+		e.dir = dir
+	}
+}
+
+// WithEnvVars adds env vars to every command, replacing env vars with the same name
+func WithEnvVars(vars map[string]string) ScopeOption {
+	return func(e *scopeExec) {
+		for name, value := range vars {
+			e.env[name] = value
+		}
+	}
+}
+
+// WithArgRewrite rewrites the command line of every command, including the command name,
+// before the command is created. Rewrites are applied in the order they're added
+func WithArgRewrite(rewrite func(args []string) []string) ScopeOption {
+	return func(e *scopeExec) {
+		e.rewrites = append(e.rewrites, rewrite)
+	}
+}
+
+// WithAlias runs target whenever name is run, e.g. WithAlias("python", "python3").
+// LookPath resolves the alias as well
+func WithAlias(name, target string) ScopeOption {
+	return WithArgRewrite(func(args []string) []string {
+		if len(args) > 0 && args[0] == name {
+			args[0] = target
+		}
+		return args
+	})
+}
+
+// Scope returns an Exec that applies default settings to every Cmd it creates with e.
+// The defaults are applied when the Cmd is created, so they can still be changed with
+// SetDir or SetEnv before it runs
+func Scope(e Exec, opts ...ScopeOption) Exec {
+	scope := &scopeExec{base: e, env: map[string]string{}}
+	for _, opt := range opts {
+		opt(scope)
+	}
+
+	return scope
+}
+
+// scopeExec is an Exec that applies default settings to the commands created by the base Exec
+type scopeExec struct {
+	base     Exec
+	dir      string
+	env      map[string]string
+	rewrites []func(args []string) []string
+}
+
+// LookPath calls LookPath on the base Exec with the aliases and rewrites applied to file
+func (e *scopeExec) LookPath(file string) (string, error) {
+	if args := e.rewrite(file, nil); len(args) > 0 {
+		file = args[0]
+	}
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd with the base Exec and applies the defaults of the scope
+func (e *scopeExec) Command(name string, arg ...string) Cmd {
+	args := e.rewrite(name, arg)
+	if len(args) == 0 {
+		return e.apply(e.base.Command(""))
+	}
+	return e.apply(e.base.Command(args[0], args[1:]...))
+}
+
+// CommandContext creates a Cmd with the base Exec and applies the defaults of the scope
+func (e *scopeExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	args := e.rewrite(name, arg)
+	if len(args) == 0 {
+		return e.apply(e.base.CommandContext(ctx, ""))
+	}
+	return e.apply(e.base.CommandContext(ctx, args[0], args[1:]...))
+}
+
+// rewrite applies the rewrites of the scope to a command line
+func (e *scopeExec) rewrite(name string, arg []string) []string {
+	args := append([]string{name}, arg...)
+	for _, rewrite := range e.rewrites {
+		args = rewrite(args)
+	}
+	return args
+}
+
+// apply sets the dir and env of the scope on cmd
+func (e *scopeExec) apply(cmd Cmd) Cmd {
+	if e.dir != "" {
+		dir := e.dir
+		if !filepath.IsAbs(dir) && cmd.Dir() != "" {
+			dir = filepath.Join(cmd.Dir(), dir)
+		}
+		cmd.SetDir(dir)
+	}
+
+	if len(e.env) > 0 {
+		names := make([]string, 0, len(e.env))
+		for name := range e.env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		env := cmd.Environ()
+		for _, name := range names {
+			env = setEnvVar(env, name, e.env[name])
+		}
+		cmd.SetEnv(env)
+	}
+
+	return cmd
+}
@@ -0,0 +1,94 @@
+package puffin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	fExec := NewFuncExec(
+		WithFuncMap(map[string]CmdFunc{
+			"python3": func(fc *FuncCmd) int {
+				env := map[string]string{}
+				for _, kv := range fc.Environ() {
+					name, value, _ := strings.Cut(kv, "=")
+					env[name] = value
+				}
+				fc.Stdout().Write([]byte(strings.Join(fc.Args(), " ") + "|" + fc.Dir() + "|" + env["GOFLAGS"] + "|" + env["HOME"]))
+				return 0
+			},
+		}),
+		WithEnv(map[string]string{"HOME": "/home/puffin", "GOFLAGS": "-v"}),
+	)
+
+	tests := []struct {
+		name string
+		exec Exec
+		args []string
+		want string
+	}{
+		{
+			"no defaults",
+			Scope(fExec),
+			[]string{"python3", "main.py"},
+			"python3 main.py||-v|/home/puffin",
+		},
+		{
+			"dir and env",
+			Scope(fExec, WithDir("/src/repo"), WithEnvVars(map[string]string{"GOFLAGS": "-mod=mod"})),
+			[]string{"python3", "main.py"},
+			"python3 main.py|/src/repo|-mod=mod|/home/puffin",
+		},
+		{
+			"alias",
+			Scope(fExec, WithAlias("python", "python3")),
+			[]string{"python", "main.py"},
+			"python3 main.py||-v|/home/puffin",
+		},
+		{
+			"arg rewrite",
+			Scope(fExec, WithArgRewrite(func(args []string) []string {
+				return append(args, "--verbose")
+			})),
+			[]string{"python3", "main.py"},
+			"python3 main.py --verbose||-v|/home/puffin",
+		},
+		{
+			"nested",
+			Scope(Scope(fExec, WithDir("/src/repo")), WithDir("tools"), WithAlias("python", "python3")),
+			[]string{"python", "main.py"},
+			"python3 main.py|/src/repo/tools|-v|/home/puffin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.exec.Command(tt.args[0], tt.args[1:]...).Output()
+			if err != nil {
+				t.Fatalf("Output() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Output() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestScope_override(t *testing.T) {
+	fExec := NewFuncExec(WithFuncMap(map[string]CmdFunc{
+		"pwd": func(fc *FuncCmd) int {
+			fc.Stdout().Write([]byte(fc.Dir()))
+			return 0
+		},
+	}))
+	exec := Scope(fExec, WithDir("/src/repo"), WithAlias("cwd", "pwd"))
+
+	cmd := exec.Command("pwd")
+	cmd.SetDir("/tmp")
+	if out, _ := cmd.Output(); string(out) != "/tmp" {
+		t.Errorf("Output() = %q, want the dir set on the Cmd", out)
+	}
+
+	if path, err := exec.LookPath("cwd"); err != nil || path != "pwd" {
+		t.Errorf("LookPath(cwd) = %q, %v, want the alias to be resolved", path, err)
+	}
+}
@@ -0,0 +1,422 @@
+// Package script runs end to end tests of go command line tools written as txtar scripts.
+// Every subprocess the tool starts is served by puffin fakes, so scripts never touch the real shell.
+//
+// The comment section of the archive holds the script, one command per line.
+// Archive files whose names start with "fake " define fake commands using the
+// puffin.ParseFake format, all other files are written to the scripts work directory.
+//
+//	# build the release notes
+//	exec release notes v1.0.0
+//	stdout 'wrote notes.md'
+//	cmp notes.md want.md
+//	! exec release notes
+//	stderr 'missing version'
+//
+//	-- fake git log --format=%s ... --
+//	stdout fix the thing
+//	-- want.md --
+//	- fix the thing
+//
+// The following commands are supported, prefixing a command with ! negates it
+//
+//	exec program [args...]  run a program, the program must exit 0 (or not, with !)
+//	stdout regexp           the last programs standard output must match the regexp
+//	stderr regexp           the last programs standard error must match the regexp
+//	cmp file1 file2         the files must be identical, stdout and stderr name the last output
+//	exists file             the file must exist
+//	stdin file              use the file as standard input for the next exec
+//	env NAME=value          set an environment variable for programs and fakes
+//	cd dir                  change the working directory
+//
+// Arguments can be quoted with single or double quotes and $WORK, along with any
+// variable set with env, is expanded to its value.
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bjatkin/puffin"
+	"github.com/bjatkin/puffin/internal/txtar"
+	"github.com/bjatkin/puffin/internal/words"
+)
+
+// Invocation holds everything a Program needs to run
+type Invocation struct {
+	// Exec serves all the commands the program runs using the scripts fakes
+	Exec puffin.Exec
+
+	// Args are the command line arguments, including the program name as Args[0]
+	Args []string
+
+	// Dir is the working directory the program was invoked from
+	Dir string
+
+	// Env is the environment the program was invoked with
+	Env map[string]string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Program is the go entrypoint of a command line tool, it returns the tools exit code
+type Program func(inv *Invocation) int
+
+// Params configures how scripts are run
+type Params struct {
+	// Dir is the directory containing the .txtar scripts
+	Dir string
+
+	// Programs maps the names used by exec to go entrypoints
+	Programs map[string]Program
+
+	// Funcs are fake commands available to every script in addition to the fakes the script defines
+	Funcs map[string]puffin.CmdFunc
+}
+
+// Run runs every .txtar script in p.Dir as a sub test of t
+func Run(t *testing.T, p Params) {
+	files, err := filepath.Glob(filepath.Join(p.Dir, "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no scripts found in %s", p.Dir)
+	}
+
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".txtar")
+		t.Run(name, func(t *testing.T) {
+			RunFile(t, file, p)
+		})
+	}
+}
+
+// RunFile runs a single script
+func RunFile(t *testing.T, file string, p Params) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runScript(filepath.Base(file), data, t.TempDir(), p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// state is the state of a running script
+type state struct {
+	params Params
+	work   string
+	dir    string
+	env    map[string]string
+	fakes  []puffin.Fake
+
+	stdin  []byte
+	stdout []byte
+	stderr []byte
+}
+
+// runScript runs the script in data using work as the work directory
+func runScript(name string, data []byte, work string, p Params) error {
+	archive := txtar.Parse(data)
+	s := &state{
+		params: p,
+		work:   work,
+		dir:    work,
+		env:    map[string]string{"WORK": work},
+	}
+
+	for _, file := range archive.Files {
+		if pattern := strings.TrimPrefix(file.Name, "fake "); pattern != file.Name {
+			fake, err := puffin.ParseFake(pattern, file.Data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			s.fakes = append(s.fakes, fake)
+			continue
+		}
+
+		path := filepath.Join(work, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, file.Data, 0o666); err != nil {
+			return err
+		}
+	}
+
+	lines := strings.Split(string(archive.Comment), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := s.runLine(line); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", name, i+1, line, err)
+		}
+	}
+
+	return nil
+}
+
+// runLine runs a single line of the script
+func (s *state) runLine(line string) error {
+	args, err := words.Split(line)
+	if err != nil {
+		return err
+	}
+	for i := range args {
+		args[i] = os.Expand(args[i], func(name string) string {
+			return s.env[name]
+		})
+	}
+
+	neg := false
+	if args[0] == "!" {
+		neg = true
+		args = args[1:]
+		if len(args) == 0 {
+			return fmt.Errorf("missing command after !")
+		}
+	}
+
+	cmd, ok := scriptCmds[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return cmd(s, neg, args[1:])
+}
+
+// scriptCmd is a command that can be used in a script
+type scriptCmd func(s *state, neg bool, args []string) error
+
+// scriptCmds maps the script command names to their implementations
+var scriptCmds = map[string]scriptCmd{
+	"cd":     (*state).cd,
+	"cmp":    (*state).cmp,
+	"env":    (*state).setEnv,
+	"exec":   (*state).exec,
+	"exists": (*state).exists,
+	"stderr": (*state).matchStderr,
+	"stdin":  (*state).setStdin,
+	"stdout": (*state).matchStdout,
+}
+
+// path resolves a file name relative to the current directory
+func (s *state) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// exec runs one of the scripts programs
+func (s *state) exec(neg bool, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: exec program [args...]")
+	}
+
+	prog, ok := s.params.Programs[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown program %q", args[0])
+	}
+
+	env := make(map[string]string, len(s.env))
+	for k, v := range s.env {
+		env[k] = v
+	}
+
+	var stdout, stderr bytes.Buffer
+	inv := &Invocation{
+		Exec: puffin.NewFuncExec(
+			puffin.WithFuncMap(s.params.Funcs),
+			puffin.WithFakes(s.fakes),
+			puffin.WithEnv(env),
+		),
+		Args:   args,
+		Dir:    s.dir,
+		Env:    env,
+		Stdin:  bytes.NewReader(s.stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+
+	code := prog(inv)
+	s.stdin = nil
+	s.stdout, s.stderr = stdout.Bytes(), stderr.Bytes()
+
+	switch {
+	case code != 0 && !neg:
+		return fmt.Errorf("program exited with code %d\nstdout:\n%s\nstderr:\n%s", code, s.stdout, s.stderr)
+	case code == 0 && neg:
+		return fmt.Errorf("program succeeded unexpectedly\nstdout:\n%s\nstderr:\n%s", s.stdout, s.stderr)
+	}
+
+	return nil
+}
+
+// matchStdout checks the last programs stdout against a regexp
+func (s *state) matchStdout(neg bool, args []string) error {
+	return match("stdout", s.stdout, neg, args)
+}
+
+// matchStderr checks the last programs stderr against a regexp
+func (s *state) matchStderr(neg bool, args []string) error {
+	return match("stderr", s.stderr, neg, args)
+}
+
+// match checks output against the regexp in args
+func match(name string, output []byte, neg bool, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s regexp", name)
+	}
+
+	re, err := regexp.Compile("(?m)" + args[0])
+	if err != nil {
+		return err
+	}
+
+	switch matched := re.Match(output); {
+	case !matched && !neg:
+		return fmt.Errorf("no match for %#q in %s:\n%s", args[0], name, output)
+	case matched && neg:
+		return fmt.Errorf("unexpected match for %#q in %s:\n%s", args[0], name, output)
+	}
+
+	return nil
+}
+
+// read returns the contents of a file, stdout and stderr refer to the last programs output
+func (s *state) read(name string) ([]byte, error) {
+	switch name {
+	case "stdout":
+		return s.stdout, nil
+	case "stderr":
+		return s.stderr, nil
+	default:
+		return os.ReadFile(s.path(name))
+	}
+}
+
+// cmp compares the contents of two files
+func (s *state) cmp(neg bool, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: cmp file1 file2")
+	}
+
+	a, err := s.read(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := s.read(args[1])
+	if err != nil {
+		return err
+	}
+
+	switch same := bytes.Equal(a, b); {
+	case !same && !neg:
+		return fmt.Errorf("%s and %s differ\n%s:\n%s\n%s:\n%s", args[0], args[1], args[0], a, args[1], b)
+	case same && neg:
+		return fmt.Errorf("%s and %s are identical", args[0], args[1])
+	}
+
+	return nil
+}
+
+// exists checks that files exist
+func (s *state) exists(neg bool, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: exists file...")
+	}
+
+	for _, name := range args {
+		_, err := os.Stat(s.path(name))
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return err
+		case err != nil && !neg:
+			return fmt.Errorf("%s does not exist", name)
+		case err == nil && neg:
+			return fmt.Errorf("%s exists", name)
+		}
+	}
+
+	return nil
+}
+
+// setStdin sets the stdin for the next exec
+func (s *state) setStdin(neg bool, args []string) error {
+	if neg || len(args) != 1 {
+		return fmt.Errorf("usage: stdin file")
+	}
+
+	data, err := s.read(args[0])
+	if err != nil {
+		return err
+	}
+	s.stdin = data
+
+	return nil
+}
+
+// setEnv sets environment variables, with no arguments it prints the environment to stdout
+func (s *state) setEnv(neg bool, args []string) error {
+	if neg {
+		return fmt.Errorf("usage: env [NAME=value...]")
+	}
+
+	if len(args) == 0 {
+		var b strings.Builder
+		keys := make([]string, 0, len(s.env))
+		for k := range s.env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s=%s\n", k, s.env[k])
+		}
+		s.stdout = []byte(b.String())
+		return nil
+	}
+
+	for _, arg := range args {
+		name, val, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("env: %q is not in the form NAME=value", arg)
+		}
+		s.env[name] = val
+	}
+
+	return nil
+}
+
+// cd changes the current directory
+func (s *state) cd(neg bool, args []string) error {
+	if neg || len(args) != 1 {
+		return fmt.Errorf("usage: cd dir")
+	}
+
+	dir := s.path(args[0])
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", args[0])
+	}
+	s.dir = dir
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package script
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bjatkin/puffin"
+)
+
+// notes writes release notes for a version using the subjects from git log
+func notes(inv *Invocation) int {
+	if len(inv.Args) != 2 {
+		fmt.Fprintln(inv.Stderr, "usage: notes <version>")
+		return 2
+	}
+
+	cmd := inv.Exec.Command("git", "log", "--format=%s", inv.Args[1])
+	cmd.SetStderr(inv.Stderr)
+	out, err := cmd.Output()
+	if err != nil {
+		return 1
+	}
+
+	notes := "# " + inv.Args[1] + "\n"
+	for _, subject := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		notes += "- " + subject + "\n"
+	}
+
+	if err := os.WriteFile(filepath.Join(inv.Dir, "notes.md"), []byte(notes), 0o666); err != nil {
+		fmt.Fprintln(inv.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintln(inv.Stdout, "wrote notes.md")
+	return 0
+}
+
+// upper copies stdin to stdout in upper case
+func upper(inv *Invocation) int {
+	in, err := io.ReadAll(inv.Stdin)
+	if err != nil {
+		return 1
+	}
+	fmt.Fprint(inv.Stdout, strings.ToUpper(string(in)))
+	return 0
+}
+
+func TestRun(t *testing.T) {
+	Run(t, Params{
+		Dir: "testdata",
+		Programs: map[string]Program{
+			"notes": notes,
+			"upper": upper,
+		},
+	})
+}
+
+func Test_runScript(t *testing.T) {
+	params := Params{
+		Programs: map[string]Program{
+			"upper": upper,
+		},
+		Funcs: map[string]puffin.CmdFunc{
+			"true": func(fc *puffin.FuncCmd) int { return 0 },
+		},
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			"passing script",
+			"env NAME=puffin\nexec upper $NAME\n! stdout .\n",
+			"",
+		},
+		{
+			"unknown command",
+			"frobnicate\n",
+			`test.txtar:1: frobnicate: unknown command "frobnicate"`,
+		},
+		{
+			"unknown program",
+			"exec missing\n",
+			`test.txtar:1: exec missing: unknown program "missing"`,
+		},
+		{
+			"stdout mismatch",
+			"# comment\nexec upper\nstdout hello\n",
+			"test.txtar:3: stdout hello: no match for `hello` in stdout:\n",
+		},
+		{
+			"missing file",
+			"exists missing.txt\n",
+			"test.txtar:1: exists missing.txt: missing.txt does not exist",
+		},
+		{
+			"bad quotes",
+			"exec 'upper\n",
+			"test.txtar:1: exec 'upper: unterminated ' quote",
+		},
+		{
+			"bad fake",
+			"exec upper\n-- fake git --\nexit zero\n",
+			`test.txtar: fake "git" line 1: strconv.Atoi: parsing "zero": invalid syntax`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runScript("test.txtar", []byte(tt.script), t.TempDir(), params)
+			var gotErr string
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("runScript() error = %q, want %q", gotErr, tt.wantErr)
+			}
+		})
+	}
+}
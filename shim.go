@@ -0,0 +1,426 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Clone creates a new Cmd with e that has the same path, args, env, dir, extra files,
+// sys proc attr, resource limits, process group and stop signal as cmd. Standard input and output
+// are not copied, and neither is any state from running cmd, so the clone can be started even
+// if cmd has already run. If ctx is nil the clone is created with Command rather than CommandContext
+func Clone(ctx context.Context, e Exec, cmd Cmd) Cmd {
+	args := append([]string(nil), cmd.Args()...)
+	var rest []string
+	if len(args) > 0 {
+		rest = args[1:]
+	}
+
+	var clone Cmd
+	if ctx == nil {
+		clone = e.Command(cmd.Path(), rest...)
+	} else {
+		clone = e.CommandContext(ctx, cmd.Path(), rest...)
+	}
+
+	clone.SetPath(cmd.Path())
+	clone.SetArgs(args)
+	if env := cmd.Env(); env != nil {
+		clone.SetEnv(append([]string(nil), env...))
+	}
+	clone.SetDir(cmd.Dir())
+	if files := cmd.ExtraFiles(); files != nil {
+		clone.SetExtraFiles(append([]*os.File(nil), files...))
+	}
+	if src, ok := findCmd[*OsCmd](cmd); ok {
+		if dst, ok := findCmd[*OsCmd](clone); ok {
+			if len(src.rlimits) > 0 {
+				dst.SetRlimits(src.rlimits...)
+			}
+			if src.processGroup {
+				dst.SetProcessGroup(true)
+			}
+			if src.stopSignal != nil {
+				dst.SetStopSignal(src.stopSignal)
+			}
+		}
+	}
+	if attr := cmd.SysProcAttr(); attr != nil {
+		if setter, ok := findCmd[sysProcAttrSetter](clone); ok {
+			copied := *attr
+			setter.SetSysProcAttr(&copied)
+		}
+	}
+
+	return clone
+}
+
+// sysProcAttrSetter is implemented by Cmds that can set their sys proc attr
+type sysProcAttrSetter interface {
+	SetSysProcAttr(attr *syscall.SysProcAttr)
+}
+
+// findCmd returns the first Cmd in the chain of wrapped Cmds, starting with cmd,
+// that is a T. Wrapped Cmds are found with an Unwrap() Cmd method
+func findCmd[T any](cmd Cmd) (T, bool) {
+	for cmd != nil {
+		if found, ok := cmd.(T); ok {
+			return found, true
+		}
+
+		unwrapper, ok := cmd.(interface{ Unwrap() Cmd })
+		if !ok {
+			break
+		}
+		cmd = unwrapper.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// shimFunc executes a shimCmd. It reads the commands input from stdin, writes its output
+// to stdout and stderr and returns the error that Wait should return
+type shimFunc func(s *shimCmd, stdin io.Reader, stdout, stderr io.Writer) error
+
+// shimCmd is a Cmd whose execution is replaced by a shimFunc. It's used by middleware that
+// need to decide how, or if, a command runs, e.g. running it several times or not at all.
+// The embedded Cmd holds the configuration of the command and is never started by the shim,
+// the shimFunc usually runs a Clone of it. Standard input and output, pipes, Start and Wait
+// are implemented by the shim itself
+type shimCmd struct {
+	Cmd
+	ctx context.Context
+	fn  shimFunc
+
+	// prepare, if not nil, is called by Start before fn runs. If it returns an error
+	// the command is not started and Start returns the error
+	prepare func() error
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// closeAfterWait are the pipe ends the shim closes once fn has returned
+	closeAfterWait []io.Closer
+
+	started bool
+	waited  bool
+	done    chan struct{}
+	err     error
+
+	// stop is closed by Stop, running is the Cmd the shimFunc is running that Stop stops and
+	// stopGrace is the grace period Stop was called with
+	mu           sync.Mutex
+	stop         chan struct{}
+	stopGrace    time.Duration
+	running      Cmd
+	process      *os.Process
+	processState *os.ProcessState
+}
+
+// errStopped is returned by a shim that was stopped before it ran a Cmd
+var errStopped = errors.New("puffin: command was stopped")
+
+// newShimCmd creates a shimCmd that is configured by cmd and executed by fn.
+// ctx is the context the command was created with, it may be nil
+func newShimCmd(ctx context.Context, cmd Cmd, fn shimFunc) *shimCmd {
+	return &shimCmd{Cmd: cmd, ctx: ctx, fn: fn}
+}
+
+// Unwrap returns the Cmd that holds the configuration of the shim
+func (s *shimCmd) Unwrap() Cmd {
+	return s.Cmd
+}
+
+// context returns the context the command was created with, or context.Background
+func (s *shimCmd) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error
+func (s *shimCmd) CombinedOutput() ([]byte, error) {
+	return combinedOutput(s)
+}
+
+// Output runs the command and returns its standard output
+func (s *shimCmd) Output() ([]byte, error) {
+	return output(s)
+}
+
+// Run starts the command and waits for it to complete
+func (s *shimCmd) Run() error {
+	return run(s)
+}
+
+// Start runs the shimFunc in a new go routine
+func (s *shimCmd) Start() error {
+	if s.started {
+		return errors.New("exec: already started")
+	}
+	if err := s.Cmd.Err(); err != nil {
+		return err
+	}
+	if s.ctx != nil {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if s.prepare != nil {
+		if err := s.prepare(); err != nil {
+			return err
+		}
+	}
+	s.started = true
+	s.stop = make(chan struct{})
+
+	stdin := s.stdin
+	if stdin == nil {
+		stdin = bytes.NewReader(nil)
+	}
+	stdout, stderr := orDiscard(s.stdout), orDiscard(s.stderr)
+
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.err = s.fn(s, stdin, stdout, stderr)
+
+		for _, closer := range s.closeAfterWait {
+			closer.Close()
+		}
+	}()
+
+	return nil
+}
+
+// Wait waits for the shimFunc to return
+func (s *shimCmd) Wait() error {
+	if !s.started {
+		return errors.New("exec: not started")
+	}
+	if s.waited {
+		return errors.New("exec: Wait was already called")
+	}
+	s.waited = true
+
+	<-s.done
+	return s.err
+}
+
+// Stop stops the Cmd the shimFunc is running, see OsCmd.Stop, and the shimFunc doesn't run any
+// more Cmds, e.g. a retry is not attempted. If no Cmd is running, e.g. between retries, Stop waits
+// for the shimFunc to return and returns StopExited
+func (s *shimCmd) Stop(ctx context.Context, grace time.Duration) (StopResult, error) {
+	if !s.started {
+		return 0, errors.New("puffin: not started")
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		s.stopGrace = grace
+		close(s.stop)
+	}
+	running := s.running
+	s.mu.Unlock()
+
+	if running != nil {
+		return Stop(ctx, running, grace)
+	}
+
+	select {
+	case <-s.done:
+		return StopExited, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// stopped reports whether Stop has been called
+func (s *shimCmd) stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for d. It returns the context error if the context of the shim is done first,
+// or errStopped if the shim is stopped first
+func (s *shimCmd) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.context().Done():
+		return s.context().Err()
+	case <-s.stop:
+		return errStopped
+	}
+}
+
+// StdinPipe returns a pipe that is connected to the commands standard input
+func (s *shimCmd) StdinPipe() (io.WriteCloser, error) {
+	if s.stdin != nil {
+		return nil, errors.New("exec: Stdin already set")
+	}
+	if s.started {
+		return nil, errors.New("exec: StdinPipe after process started")
+	}
+
+	pr, pw := io.Pipe()
+	s.stdin = pr
+	s.closeAfterWait = append(s.closeAfterWait, pr)
+	return pw, nil
+}
+
+// StdoutPipe returns a pipe that is connected to the commands standard output
+func (s *shimCmd) StdoutPipe() (io.ReadCloser, error) {
+	if s.stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if s.started {
+		return nil, errors.New("exec: StdoutPipe after process started")
+	}
+
+	pr, pw := io.Pipe()
+	s.stdout = pw
+	s.closeAfterWait = append(s.closeAfterWait, pw)
+	return pr, nil
+}
+
+// StderrPipe returns a pipe that is connected to the commands standard error
+func (s *shimCmd) StderrPipe() (io.ReadCloser, error) {
+	if s.stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	if s.started {
+		return nil, errors.New("exec: StderrPipe after process started")
+	}
+
+	pr, pw := io.Pipe()
+	s.stderr = pw
+	s.closeAfterWait = append(s.closeAfterWait, pw)
+	return pr, nil
+}
+
+// Stdin returns the commands standard input
+func (s *shimCmd) Stdin() io.Reader {
+	return s.stdin
+}
+
+// SetStdin sets the commands standard input
+func (s *shimCmd) SetStdin(stdin io.Reader) {
+	s.stdin = stdin
+}
+
+// Stdout returns the commands standard output
+func (s *shimCmd) Stdout() io.Writer {
+	return s.stdout
+}
+
+// SetStdout sets the commands standard output
+func (s *shimCmd) SetStdout(stdout io.Writer) {
+	s.stdout = stdout
+}
+
+// Stderr returns the commands standard error
+func (s *shimCmd) Stderr() io.Writer {
+	return s.stderr
+}
+
+// SetStderr sets the commands standard error
+func (s *shimCmd) SetStderr(stderr io.Writer) {
+	s.stderr = stderr
+}
+
+// Process returns the process of the Cmd the shimFunc is currently running, if any
+func (s *shimCmd) Process() *os.Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.process
+}
+
+// ProcessState returns the process state of the last Cmd the shimFunc ran, if any
+func (s *shimCmd) ProcessState() *os.ProcessState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.processState
+}
+
+// runClone runs a Clone of the shim created with e, connected to stdin, stdout and stderr.
+// The clone is recorded by the shim, its process is reported by Process and Stop stops it
+func (s *shimCmd) runClone(ctx context.Context, e Exec, stdin io.Reader, stdout, stderr io.Writer) error {
+	return s.execClone(ctx, e, stdin, stdout, stderr, true)
+}
+
+// runShared runs a Clone like runClone, but the clone's run is shared with other shims
+// so Stop doesn't stop it
+func (s *shimCmd) runShared(ctx context.Context, e Exec, stdin io.Reader, stdout, stderr io.Writer) error {
+	return s.execClone(ctx, e, stdin, stdout, stderr, false)
+}
+
+// execClone runs a Clone of the shim, if stoppable is set the clone is not started once the
+// shim is stopped and it's stopped by Stop
+func (s *shimCmd) execClone(ctx context.Context, e Exec, stdin io.Reader, stdout, stderr io.Writer, stoppable bool) error {
+	clone := Clone(ctx, e, s.Cmd)
+	clone.SetStdin(stdin)
+	clone.SetStdout(stdout)
+	clone.SetStderr(stderr)
+
+	if stoppable && s.stopped() {
+		return errStopped
+	}
+	// the lock is not held while the clone starts, Start can block, e.g. waiting for a limiter
+	if err := clone.Start(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.process = clone.Process()
+	stopped := false
+	if stoppable {
+		s.running = clone
+		select {
+		case <-s.stop:
+			stopped = true
+		default:
+		}
+	}
+	grace := s.stopGrace
+	s.mu.Unlock()
+
+	// Stop was called while the clone was starting, before it could find the clone.
+	// Stop doesn't reap the clone so it runs alongside Wait
+	if stopped {
+		go Stop(context.Background(), clone, grace)
+	}
+
+	err := clone.Wait()
+
+	s.mu.Lock()
+	if stoppable {
+		s.running = nil
+	}
+	s.processState = clone.ProcessState()
+	s.mu.Unlock()
+	return err
+}
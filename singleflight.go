@@ -0,0 +1,173 @@
+package puffin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// WithSingleflight returns a Middleware that collapses identical commands that run at the same
+// time into a single run. Commands are identical when they have the same path, args, env, dir and
+// standard input. Every Cmd gets a copy of the output and the error of the shared run once it
+// completes, including Cmds that read their output from a pipe. Standard input is read in full
+// before the command runs since it's part of the key. Unlike a Cache, results are not kept, a
+// command that starts after the shared run completed runs again.
+//
+// Only commands that match one of the rules are collapsed, with no rules every command is.
+// A Cmd whose context is canceled stops waiting, the shared run is only canceled once every
+// Cmd waiting for it has been canceled
+func WithSingleflight(rules ...PolicyRule) Middleware {
+	policy := &Policy{Allow: rules}
+	return func(base Exec) Exec {
+		return &flightExec{base: base, policy: policy, calls: map[string]*flightCall{}}
+	}
+}
+
+// flightCall is a run of a command that several Cmds are waiting for
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	stdout []byte
+	stderr []byte
+	err    error
+}
+
+// flightExec is an Exec that collapses identical commands created by the base Exec
+type flightExec struct {
+	base   Exec
+	policy *Policy
+
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *flightExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that shares its run with identical commands
+func (e *flightExec) Command(name string, arg ...string) Cmd {
+	return newShimCmd(nil, e.base.Command(name, arg...), e.run)
+}
+
+// CommandContext creates a Cmd that shares its run with identical commands
+func (e *flightExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	return newShimCmd(ctx, e.base.CommandContext(ctx, name, arg...), e.run)
+}
+
+// run joins the run of an identical command, or starts one, and writes its output
+func (e *flightExec) run(s *shimCmd, stdin io.Reader, stdout, stderr io.Writer) error {
+	if e.policy.Check(s.Cmd) != nil {
+		return s.runClone(s.ctx, e.base, stdin, stdout, stderr)
+	}
+
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	combined := sameWriter(stdout, stderr)
+	key, err := commandKey(s.Cmd, input, combined, nil)
+	if err != nil {
+		return s.runClone(s.ctx, e.base, bytes.NewReader(input), stdout, stderr)
+	}
+
+	call := e.join(s, key, input, combined)
+	ctx := s.context()
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		e.leave(key, call)
+		return ctx.Err()
+	case <-s.stop:
+		// stopping one of the commands doesn't stop the run the others are waiting for
+		e.leave(key, call)
+		return errStopped
+	}
+
+	stdout.Write(call.stdout)
+	if !combined {
+		stderr.Write(call.stderr)
+	}
+	return ownError(call.err)
+}
+
+// ownError returns a copy of err that one waiter can change without affecting the others,
+// e.g. Output sets the Stderr of an *exec.ExitError. Errors that don't hold one are not copied
+func ownError(err error) error {
+	switch e := err.(type) {
+	case *exec.ExitError:
+		copied := *e
+		return &copied
+	case *RlimitError:
+		copied := *e
+		copied.Err = ownError(e.Err)
+		return &copied
+	case *TimeoutError:
+		copied := *e
+		copied.Err = ownError(e.Err)
+		return &copied
+	}
+	return err
+}
+
+// join returns the call running the command with the given key, a new call is started
+// if there isn't one
+func (e *flightExec) join(s *shimCmd, key string, input []byte, combined bool) *flightCall {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if call, ok := e.calls[key]; ok {
+		call.waiters++
+		return call
+	}
+
+	// the call is not tied to the context of the Cmd that started it since other Cmds may
+	// still be waiting for it after that Cmd is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	call := &flightCall{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	e.calls[key] = call
+
+	go func() {
+		defer cancel()
+
+		var outBuf, errBuf bytes.Buffer
+		cmdOut, cmdErr := io.Writer(&outBuf), io.Writer(&errBuf)
+		if combined {
+			sw := &syncWriter{w: &outBuf}
+			cmdOut, cmdErr = sw, sw
+		}
+		err := s.runShared(ctx, e.base, bytes.NewReader(input), cmdOut, cmdErr)
+
+		e.mu.Lock()
+		if e.calls[key] == call {
+			delete(e.calls, key)
+		}
+		e.mu.Unlock()
+
+		call.stdout, call.stderr, call.err = outBuf.Bytes(), errBuf.Bytes(), err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// leave stops waiting for a call, the call is canceled if nothing is waiting for it anymore
+func (e *flightExec) leave(key string, call *flightCall) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	call.cancel()
+	if e.calls[key] == call {
+		delete(e.calls, key)
+	}
+}
@@ -0,0 +1,200 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// flightWaiters returns the number of Cmds waiting for a shared run
+func flightWaiters(e *flightExec) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	waiters := 0
+	for _, call := range e.calls {
+		waiters += call.waiters
+	}
+	return waiters
+}
+
+// gatedExec returns an Exec that collapses kubectl commands, they block until release is closed
+func gatedExec() (*flightExec, *int32, chan struct{}) {
+	runs, release := int32(0), make(chan struct{})
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"kubectl": func(fc *FuncCmd) int {
+				atomic.AddInt32(&runs, 1)
+				<-release
+				fc.Stdout().Write([]byte("pods\n"))
+				fc.Stderr().Write([]byte("warning\n"))
+				return 2
+			},
+		})),
+		WithSingleflight(PolicyRule{Command: "kubectl", Args: []string{"get", "..."}}),
+	)
+	return exec.(*flightExec), &runs, release
+}
+
+func TestWithSingleflight(t *testing.T) {
+	exec, runs, release := gatedExec()
+
+	type result struct {
+		out  string
+		code int
+	}
+	results := make(chan result, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		cmd := exec.Command("kubectl", "get", "pods")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := cmd.Output()
+			results <- result{string(out), ExitCode(err)}
+		}()
+	}
+
+	piped := exec.Command("kubectl", "get", "pods")
+	stdout, err := piped.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() error = %v", err)
+	}
+	if err := piped.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		out, _ := io.ReadAll(stdout)
+		err := piped.Wait()
+		results <- result{string(out), ExitCode(err)}
+	}()
+
+	waitFor(t, func() bool { return flightWaiters(exec) == 5 })
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		if res.out != "pods\n" || res.code != 2 {
+			t.Errorf("got output %q exit code %d, want %q and 2", res.out, res.code, "pods\n")
+		}
+	}
+	if atomic.LoadInt32(runs) != 1 {
+		t.Errorf("command ran %d times, want 1", atomic.LoadInt32(runs))
+	}
+
+	// the run is over so the next command runs again
+	exec.Command("kubectl", "get", "pods").Run()
+	if atomic.LoadInt32(runs) != 2 {
+		t.Errorf("command ran %d times, want 2", atomic.LoadInt32(runs))
+	}
+}
+
+func TestWithSingleflight_notShared(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  [][]string
+		stdin []string
+	}{
+		{"different args", [][]string{{"get", "pods"}, {"get", "nodes"}}, []string{"", ""}},
+		{"different stdin", [][]string{{"get", "pods"}, {"get", "pods"}}, []string{"a", "b"}},
+		{"no matching rule", [][]string{{"apply"}, {"apply"}}, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec, runs, release := gatedExec()
+
+			var wg sync.WaitGroup
+			for i, args := range tt.args {
+				cmd := exec.Command("kubectl", args...)
+				if tt.stdin[i] != "" {
+					cmd.SetStdin(strings.NewReader(tt.stdin[i]))
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cmd.Run()
+				}()
+			}
+
+			// both commands run at the same time rather than sharing a run
+			waitFor(t, func() bool { return atomic.LoadInt32(runs) == 2 })
+			close(release)
+			wg.Wait()
+		})
+	}
+}
+
+func TestWithSingleflight_canceled(t *testing.T) {
+	exec, runs, release := gatedExec()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := exec.CommandContext(ctx, "kubectl", "get", "pods")
+	if err := canceled.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	waiting := exec.Command("kubectl", "get", "pods")
+	if err := waiting.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, func() bool { return flightWaiters(exec) == 2 })
+
+	cancel()
+	if err := canceled.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := waiting.Wait(); ExitCode(err) != 2 {
+		t.Errorf("Wait() error = %v, want exit code 2", err)
+	}
+	if atomic.LoadInt32(runs) != 1 {
+		t.Errorf("command ran %d times, want 1", atomic.LoadInt32(runs))
+	}
+}
+
+func TestWithSingleflight_ownError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	fExec := Wrap(NewOsExec(), WithSingleflight())
+
+	const callers = 5
+	errs := make([]*exec.ExitError, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := fExec.Command("sh", "-c", "echo failed >&2; sleep 0.1; exit 1").Output()
+			if !errors.As(err, &errs[i]) {
+				t.Errorf("Output() error = %v, want an *exec.ExitError", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if string(err.Stderr) != "failed\n" {
+			t.Errorf("caller %d: ExitError.Stderr = %q, want %q", i, err.Stderr, "failed\n")
+		}
+		for _, other := range errs[:i] {
+			if other == err {
+				t.Errorf("caller %d shares its error with another caller", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// StopResult reports which step of Stop ended a command
+type StopResult int
+
+const (
+	// StopExited means the command had already exited before it was signaled
+	StopExited StopResult = iota + 1
+
+	// StopSignaled means the command exited within the grace period after it was signaled
+	StopSignaled
+
+	// StopKilled means the command was killed because it was still running after the grace period,
+	// or because it could not be signaled
+	StopKilled
+)
+
+func (r StopResult) String() string {
+	switch r {
+	case StopExited:
+		return "exited"
+	case StopSignaled:
+		return "signaled"
+	case StopKilled:
+		return "killed"
+	default:
+		return fmt.Sprintf("StopResult(%d)", int(r))
+	}
+}
+
+// stopper is implemented by Cmds that can be stopped gracefully
+type stopper interface {
+	Stop(ctx context.Context, grace time.Duration) (StopResult, error)
+}
+
+// Stop gracefully stops cmd, see OsCmd.Stop. Cmds wrapped by middleware are stopped by stopping
+// the OsCmd or FuncCmd they wrap, and Wait should still be called on cmd to get its error
+func Stop(ctx context.Context, cmd Cmd, grace time.Duration) (StopResult, error) {
+	s, ok := findCmd[stopper](cmd)
+	if !ok {
+		return 0, fmt.Errorf("puffin: %s can not be stopped", commandName(cmd))
+	}
+	return s.Stop(ctx, grace)
+}
+
+// WithStopSignal sets the signal Stop sends to every command created by the OsExec,
+// see OsCmd.SetStopSignal
+func WithStopSignal(sig os.Signal) OsExecOption {
+	return func(e *OsExec) {
+		e.stopSignal = sig
+	}
+}
+
+// SetStopSignal sets the signal Stop sends to the process, the default is SIGTERM
+func (c *OsCmd) SetStopSignal(sig os.Signal) {
+	c.stopSignal = sig
+}
+
+// stopPoll is how often Stop checks if a process has exited
+const stopPoll = 10 * time.Millisecond
+
+// Stop sends the process its stop signal, waits up to grace for it to exit and kills it if it's
+// still running. If ctx is done before then the process is killed right away, and if it's done
+// before the process exits Stop returns the ctx error. Commands started in their own process
+// group are signaled and killed as a group. Stop doesn't wait for the process, Wait must still be
+// called and can be called while Stop is running. On linux Stop sees the process exit without
+// reaping it, on other platforms it only sees the exit once Wait has returned
+func (c *OsCmd) Stop(ctx context.Context, grace time.Duration) (StopResult, error) {
+	p := c.Cmd.Process
+	if p == nil {
+		return 0, errors.New("puffin: not started")
+	}
+	if processExited(p) {
+		return StopExited, nil
+	}
+
+	sig := c.stopSignal
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+
+	var err error
+	if c.processGroup {
+		err = signalGroup(p, sig)
+	} else {
+		err = p.Signal(sig)
+	}
+	if errors.Is(err, os.ErrProcessDone) {
+		return StopExited, nil
+	}
+
+	// processes that can't be signaled, like processes on windows, are killed right away
+	if err == nil {
+		graceCtx, cancel := context.WithTimeout(ctx, grace)
+		exited := waitExited(graceCtx, p)
+		cancel()
+		if exited {
+			return StopSignaled, nil
+		}
+	}
+
+	c.KillTree()
+	if !waitExited(ctx, p) {
+		return StopKilled, ctx.Err()
+	}
+	return StopKilled, nil
+}
+
+// waitExited polls until p has exited or ctx is done, it reports whether p exited
+func waitExited(ctx context.Context, p *os.Process) bool {
+	ticker := time.NewTicker(stopPoll)
+	defer ticker.Stop()
+
+	for {
+		if processExited(p) {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return processExited(p)
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetStopSignal sets the signal Stop sends to the command, the default is SIGTERM
+func (c *FuncCmd) SetStopSignal(sig os.Signal) {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	c.stopSignal = sig
+}
+
+// Signals returns the signals sent to the command by Stop. A CmdFunc can receive from it to
+// exit gracefully, once it's killed Wait returns without waiting for the CmdFunc
+func (c *FuncCmd) Signals() <-chan os.Signal {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	if c.signals == nil {
+		c.signals = make(chan os.Signal, 2)
+	}
+	return c.signals
+}
+
+// signal sends sig to the CmdFunc without blocking
+func (c *FuncCmd) signal(sig os.Signal) {
+	c.Signals()
+
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	select {
+	case c.signals <- sig:
+	default:
+	}
+}
+
+// Stop sends the CmdFunc its stop signal through Signals, waits up to grace for it to return and
+// kills the command if it's still running. If ctx is done before then the command is killed
+// right away. A killed command's Wait returns an error with exit code -1
+func (c *FuncCmd) Stop(ctx context.Context, grace time.Duration) (StopResult, error) {
+	if c.startErr != nil {
+		return StopExited, nil
+	}
+	if c.exited == nil {
+		return 0, errors.New("puffin: not started")
+	}
+
+	select {
+	case <-c.exited:
+		return StopExited, nil
+	default:
+	}
+
+	c.stopMu.Lock()
+	sig := c.stopSignal
+	c.stopMu.Unlock()
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+	c.signal(sig)
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-c.exited:
+		return StopSignaled, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	c.stopMu.Lock()
+	select {
+	case <-c.killed:
+	default:
+		close(c.killed)
+	}
+	c.stopMu.Unlock()
+	c.signal(os.Kill)
+
+	return StopKilled, nil
+}
@@ -0,0 +1,29 @@
+package puffin
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pPID is the idtype waitid uses to wait for a single process
+const pPID = 1
+
+// processExited reports whether p has exited. The process is checked with waitid and WNOWAIT,
+// which leaves it waitable so Wait still gets its exit status and the output is still copied
+func processExited(p *os.Process) bool {
+	if errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone) {
+		return true
+	}
+
+	// si_signo is the first field of siginfo_t, it's only set if the process has exited
+	var info [128]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(p.Pid), uintptr(unsafe.Pointer(&info)),
+		syscall.WEXITED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+	if errno == syscall.ECHILD {
+		// the process was reaped by Wait
+		return true
+	}
+	return errno == 0 && *(*int32)(unsafe.Pointer(&info[0])) != 0
+}
@@ -0,0 +1,146 @@
+package puffin
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOsCmd_Stop(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		signal   os.Signal
+		canceled bool
+		want     StopResult
+		wantCode int
+		wantOut  string
+	}{
+		{"signaled", `trap "echo bye; exit 3" TERM; echo ready; while :; do sleep 0.01; done`, nil, false, StopSignaled, 3, "bye\n"},
+		{"stop signal", `trap "exit 4" INT; echo ready; while :; do sleep 0.01; done`, os.Interrupt, false, StopSignaled, 4, ""},
+		{"killed", `trap "" TERM; echo ready; exec sleep 30`, nil, false, StopKilled, -1, ""},
+		{"canceled", `trap "" TERM; echo ready; exec sleep 30`, nil, true, StopKilled, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := NewOsExec(WithStopSignal(tt.signal)).Command("sh", "-c", tt.script).(*OsCmd)
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatalf("StdoutPipe() error = %v", err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			reader := bufio.NewReader(stdout)
+			if _, err := reader.ReadString('\n'); err != nil {
+				t.Fatalf("reading ready: %v", err)
+			}
+
+			// the output is read while the command is being stopped, Stop must not close the pipe
+			rest := make(chan string, 1)
+			go func() {
+				out, _ := io.ReadAll(reader)
+				rest <- string(out)
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			grace := 50 * time.Millisecond
+			if tt.canceled {
+				cancel()
+				grace = time.Hour
+			}
+
+			got, err := cmd.Stop(ctx, grace)
+			if got != tt.want {
+				t.Errorf("Stop() = %v, want %v", got, tt.want)
+			}
+			if err != nil && !tt.canceled {
+				t.Errorf("Stop() error = %v", err)
+			}
+			if out := <-rest; out != tt.wantOut {
+				t.Errorf("output after Stop() = %q, want %q", out, tt.wantOut)
+			}
+			if code := ExitCode(cmd.Wait()); code != tt.wantCode {
+				t.Errorf("Wait() exit code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestOsCmd_Stop_exited(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cmd := NewOsExec().Command("sh", "-c", "exit 2").(*OsCmd)
+	if _, err := cmd.Stop(context.Background(), time.Second); err == nil {
+		t.Errorf("Stop() before Start error = nil, want an error")
+	}
+
+	if err := cmd.Run(); ExitCode(err) != 2 {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := cmd.Stop(context.Background(), time.Second)
+	if err != nil || got != StopExited {
+		t.Errorf("Stop() = %v, %v, want %v", got, err, StopExited)
+	}
+}
+
+func TestOsCmd_Stop_processGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cmd := NewOsExec(WithProcessGroup()).Command("sh", "-c", "sleep 30 & echo $!; wait").(*OsCmd)
+	pid := startTree(t, cmd)
+
+	got, err := Stop(context.Background(), cmd, time.Second)
+	if err != nil || got != StopSignaled {
+		t.Errorf("Stop() = %v, %v, want %v", got, err, StopSignaled)
+	}
+	waitFor(t, func() bool { return !running(pid) })
+}
+
+func TestStop_retry(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	ready := filepath.Join(t.TempDir(), "ready")
+	oExec := Wrap(NewOsExec(), WithRetry(RetryPolicy{MaxAttempts: 3, ExitCodes: []int{3}}))
+	cmd := oExec.Command("sh", "-c", `trap "exit 3" TERM; touch "$0"; while :; do sleep 0.01; done`, ready)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, func() bool {
+		_, err := os.Stat(ready)
+		return err == nil
+	})
+
+	got, err := Stop(context.Background(), cmd, time.Second)
+	if err != nil || got != StopSignaled {
+		t.Errorf("Stop() = %v, %v, want %v", got, err, StopSignaled)
+	}
+
+	// exit code 3 is retryable, but a stopped command is not retried
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if code := ExitCode(err); code != 3 {
+			t.Errorf("Wait() error = %v, want exit code 3", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Wait() did not return, the command was retried")
+	}
+}
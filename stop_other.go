@@ -0,0 +1,15 @@
+//go:build !linux
+
+package puffin
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processExited reports whether p has exited. A process can't be checked without reaping it,
+// so the exit is only seen once Wait has returned
+func processExited(p *os.Process) bool {
+	return errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
@@ -0,0 +1,250 @@
+package puffin
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFuncCmd_Stop(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	tests := []struct {
+		name     string
+		fn       CmdFunc
+		signal   os.Signal
+		canceled bool
+		want     StopResult
+		wantCode int
+	}{
+		{
+			"exited",
+			func(fc *FuncCmd) int { return 2 },
+			nil,
+			false,
+			StopExited,
+			2,
+		},
+		{
+			"signaled",
+			func(fc *FuncCmd) int {
+				if <-fc.Signals() != syscall.SIGTERM {
+					return 1
+				}
+				return 3
+			},
+			nil,
+			false,
+			StopSignaled,
+			3,
+		},
+		{
+			"stop signal",
+			func(fc *FuncCmd) int {
+				if <-fc.Signals() != os.Interrupt {
+					return 1
+				}
+				return 4
+			},
+			os.Interrupt,
+			false,
+			StopSignaled,
+			4,
+		},
+		{
+			"killed",
+			func(fc *FuncCmd) int {
+				<-release
+				return 0
+			},
+			nil,
+			false,
+			StopKilled,
+			-1,
+		},
+		{
+			"canceled",
+			func(fc *FuncCmd) int {
+				<-release
+				return 0
+			},
+			nil,
+			true,
+			StopKilled,
+			-1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := NewFuncExec(WithDefaultFunc(tt.fn)).Command("server").(*FuncCmd)
+			if tt.signal != nil {
+				cmd.SetStopSignal(tt.signal)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if tt.want == StopExited {
+				<-cmd.exited
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			grace := 20 * time.Millisecond
+			if tt.canceled {
+				cancel()
+				grace = time.Hour
+			}
+
+			got, err := cmd.Stop(ctx, grace)
+			if err != nil {
+				t.Errorf("Stop() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Stop() = %v, want %v", got, tt.want)
+			}
+			if code := ExitCode(cmd.Wait()); code != tt.wantCode {
+				t.Errorf("Wait() exit code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFuncCmd_Stop_notStarted(t *testing.T) {
+	cmd := NewFuncExec(WithDefaultFunc(Respond("", "", 0))).Command("server").(*FuncCmd)
+	if _, err := cmd.Stop(context.Background(), time.Second); err == nil {
+		t.Errorf("Stop() error = nil, want an error")
+	}
+}
+
+func TestFuncCmd_Stop_withContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	fExec := NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+		<-release
+		return 0
+	}))
+	cmd := fExec.CommandContext(context.Background(), "server").(*FuncCmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	got, err := cmd.Stop(context.Background(), 10*time.Millisecond)
+	if err != nil || got != StopKilled {
+		t.Errorf("Stop() = %v, %v, want %v", got, err, StopKilled)
+	}
+	if err := cmd.Wait(); err == nil || err.Error() != "signal: killed" {
+		t.Errorf("Wait() error = %v, want signal: killed", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	respond := NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+		<-fc.Signals()
+		return 0
+	}))
+
+	tests := []struct {
+		name        string
+		exec        Exec
+		want        StopResult
+		wantWaitErr bool
+	}{
+		{"func cmd", respond, StopSignaled, false},
+		{"middleware", Wrap(respond, WithHooks(func(ctx context.Context, cmd Cmd) *Hooks { return nil })), StopSignaled, false},
+		{"retry", Wrap(respond, WithRetry(RetryPolicy{})), StopSignaled, false},
+		{"singleflight", Wrap(respond, WithSingleflight()), StopExited, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.exec.Command("server")
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			waitFor(t, func() bool { return cmd.Process() != nil })
+
+			got, err := Stop(context.Background(), cmd, time.Second)
+			if err != nil {
+				t.Errorf("Stop() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Stop() = %v, want %v", got, tt.want)
+			}
+			if err := cmd.Wait(); (err != nil) != tt.wantWaitErr {
+				t.Errorf("Wait() error = %v, wantErr %v", err, tt.wantWaitErr)
+			}
+		})
+	}
+}
+
+func TestStop_starting(t *testing.T) {
+	limiter := NewLimiter(1)
+	exec := Wrap(NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+		<-fc.Signals()
+		return 0
+	})), WithRetry(RetryPolicy{}), limiter.Middleware())
+
+	blocker := exec.Command("blocker")
+	if err := blocker.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, func() bool { return blocker.Process() != nil })
+
+	// the retry clone waits for the limiter in Start
+	cmd := exec.Command("server")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	waitFor(t, func() bool { return limiter.Waiting() == 1 })
+
+	// Process and ProcessState must not wait for Start
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cmd.Process()
+		cmd.ProcessState()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Process() did not return while the clone was starting")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if _, err := Stop(context.Background(), cmd, time.Second); err != nil {
+			t.Errorf("Stop() error = %v", err)
+		}
+	}()
+	shim, _ := findCmd[*shimCmd](cmd)
+	waitFor(t, shim.stopped)
+
+	// the clone starts once the blocker is done, after Stop was called
+	if _, err := Stop(context.Background(), blocker, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	blocker.Wait()
+
+	waited := make(chan error)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Wait() did not return, the clone was not stopped")
+	}
+	<-stopped
+}
+
+func TestStop_notStarted(t *testing.T) {
+	cmd := Wrap(NewFuncExec(), WithRetry(RetryPolicy{})).Command("server")
+	if _, err := Stop(context.Background(), cmd, time.Second); err == nil {
+		t.Errorf("Stop() error = nil, want an error")
+	}
+}
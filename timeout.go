@@ -0,0 +1,289 @@
+package puffin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TimeoutRule sets the timeout of the commands that match Command
+type TimeoutRule struct {
+	// Command matches commands the same way as the Allow rules of a Policy
+	Command PolicyRule
+
+	// Timeout is how long matching commands may run for, 0 means they have no timeout
+	Timeout time.Duration
+}
+
+// TimeoutPolicy sets how long commands may run for
+type TimeoutPolicy struct {
+	// Default is the timeout of commands that don't match any rule, 0 means they have no timeout
+	Default time.Duration
+
+	// Rules set the timeout of specific commands, the first rule that matches is used
+	Rules []TimeoutRule
+
+	// Grace is how long a command has to exit after it's sent its stop signal before it's killed,
+	// the default is 5s. FuncCmds receive the stop signal on Signals. Commands that can't be
+	// stopped with Stop are canceled right away
+	Grace time.Duration
+}
+
+// timeout returns the timeout of the command
+func (p *TimeoutPolicy) timeout(cmd Cmd, rules []*Policy) time.Duration {
+	for i, rule := range rules {
+		if rule.Check(cmd) == nil {
+			return p.Rules[i].Timeout
+		}
+	}
+	return p.Default
+}
+
+// TimeoutError is returned by Wait when a command was stopped because it ran past its timeout.
+// It matches context.DeadlineExceeded with errors.Is
+type TimeoutError struct {
+	// Command is the command line, with secrets redacted
+	Command string
+
+	// Timeout is the timeout of the command
+	Timeout time.Duration
+
+	// Elapsed is how long the command ran for, including the grace period
+	Elapsed time.Duration
+
+	// Err is the error the command exited with
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out after %v", e.Command, e.Elapsed.Round(time.Millisecond))
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// WithTimeouts returns a Middleware that gives commands a timeout, including commands created
+// with Command which are created with CommandContext instead. The timeout starts when the command
+// is started. Once it passes the command is stopped with Stop, so it's sent SIGTERM or its stop
+// signal, then killed if it's still running after the grace period, and Wait returns a *TimeoutError.
+// Commands run by middleware like WithRetry are stopped too, and are not retried
+func WithTimeouts(policy TimeoutPolicy) Middleware {
+	if policy.Grace <= 0 {
+		policy.Grace = 5 * time.Second
+	}
+
+	var rules []*Policy
+	for _, rule := range policy.Rules {
+		rules = append(rules, &Policy{Allow: []PolicyRule{rule.Command}})
+	}
+
+	return func(base Exec) Exec {
+		return &timeoutExec{base: base, policy: &policy, rules: rules}
+	}
+}
+
+// timeoutExec is an Exec that adds timeouts to the commands created by the base Exec
+type timeoutExec struct {
+	base   Exec
+	policy *TimeoutPolicy
+	rules  []*Policy
+}
+
+// LookPath calls LookPath on the base Exec
+func (e *timeoutExec) LookPath(file string) (string, error) {
+	return e.base.LookPath(file)
+}
+
+// Command creates a Cmd that is stopped when it runs past its timeout
+func (e *timeoutExec) Command(name string, arg ...string) Cmd {
+	return e.CommandContext(context.Background(), name, arg...)
+}
+
+// CommandContext creates a Cmd that is stopped when it runs past its timeout
+func (e *timeoutExec) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	tctx := newTimeoutContext(ctx)
+	return &timeoutCmd{
+		Cmd:  e.base.CommandContext(tctx, name, arg...),
+		exec: e,
+		ctx:  tctx,
+	}
+}
+
+// timeoutContext is the context of a timeoutCmd, it's canceled when the command expires or when
+// its parent is done. Unlike context.WithCancel it's only tied to the parent while the command runs,
+// so a Cmd that is never started doesn't leave anything behind
+type timeoutContext struct {
+	context.Context
+
+	done chan struct{}
+	stop chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// newTimeoutContext creates a timeoutContext with parent, it follows the parent once watch is called
+func newTimeoutContext(parent context.Context) *timeoutContext {
+	return &timeoutContext{Context: parent, done: make(chan struct{}), stop: make(chan struct{})}
+}
+
+// Done returns a channel that is closed when the context is canceled
+func (c *timeoutContext) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the context was canceled
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// cancel cancels the context with err, if it hasn't already been canceled
+func (c *timeoutContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+// watch cancels the context when its parent is done, until release is called
+func (c *timeoutContext) watch() {
+	if err := c.Context.Err(); err != nil {
+		c.cancel(err)
+		return
+	}
+
+	parent := c.Context.Done()
+	if parent == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-parent:
+			c.cancel(c.Context.Err())
+		case <-c.stop:
+		}
+	}()
+}
+
+// release stops following the parent context
+func (c *timeoutContext) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+}
+
+// timeoutCmd is a Cmd that is stopped when it runs past its timeout
+type timeoutCmd struct {
+	Cmd
+	exec *timeoutExec
+	ctx  *timeoutContext
+
+	timeout   time.Duration
+	startTime time.Time
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired bool
+	waited  bool
+}
+
+// Unwrap returns the Cmd the timeout was added to
+func (c *timeoutCmd) Unwrap() Cmd {
+	return c.Cmd
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error
+func (c *timeoutCmd) CombinedOutput() ([]byte, error) {
+	return combinedOutput(c)
+}
+
+// Output runs the command and returns its standard output
+func (c *timeoutCmd) Output() ([]byte, error) {
+	return output(c)
+}
+
+// Run starts the command and waits for it to complete
+func (c *timeoutCmd) Run() error {
+	return run(c)
+}
+
+// Start starts the command and its timeout
+func (c *timeoutCmd) Start() error {
+	c.ctx.watch()
+	if err := c.Cmd.Start(); err != nil {
+		c.ctx.release()
+		return err
+	}
+	c.startTime = time.Now()
+
+	c.timeout = c.exec.policy.timeout(c.Cmd, c.exec.rules)
+	if c.timeout > 0 {
+		c.mu.Lock()
+		c.timer = time.AfterFunc(c.timeout, c.expire)
+		c.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Wait waits for the command to complete, if it ran past its timeout a *TimeoutError is returned
+func (c *timeoutCmd) Wait() error {
+	err := c.Cmd.Wait()
+
+	c.mu.Lock()
+	c.waited = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	expired := c.expired
+	c.mu.Unlock()
+	c.ctx.release()
+
+	if !expired {
+		return err
+	}
+	return &TimeoutError{
+		Command: DefaultRedactor().Cmd(c.Cmd),
+		Timeout: c.timeout,
+		Elapsed: time.Since(c.startTime),
+		Err:     err,
+	}
+}
+
+// expire stops a command that ran past its timeout with Stop, so it's sent its stop signal first
+// and killed once the grace period has passed. Commands that can't be stopped are canceled
+func (c *timeoutCmd) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.waited {
+		return
+	}
+	c.expired = true
+
+	if _, ok := findCmd[stopper](c.Cmd); !ok {
+		c.ctx.cancel(context.DeadlineExceeded)
+		return
+	}
+	go func() {
+		if _, err := Stop(context.Background(), c.Cmd, c.exec.policy.Grace); err != nil {
+			c.ctx.cancel(context.DeadlineExceeded)
+		}
+	}()
+}
@@ -0,0 +1,247 @@
+package puffin
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWithTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      TimeoutPolicy
+		args        []string
+		wantTimeout time.Duration
+	}{
+		{
+			"default",
+			TimeoutPolicy{Default: 20 * time.Millisecond},
+			[]string{"aws", "s3", "sync"},
+			20 * time.Millisecond,
+		},
+		{
+			"rule",
+			TimeoutPolicy{Default: time.Hour, Rules: []TimeoutRule{
+				{Command: PolicyRule{Command: "aws", Args: []string{"s3", "..."}}, Timeout: 10 * time.Millisecond},
+			}},
+			[]string{"aws", "s3", "sync"},
+			10 * time.Millisecond,
+		},
+		{
+			"first rule wins",
+			TimeoutPolicy{Rules: []TimeoutRule{
+				{Command: PolicyRule{Command: "aws"}, Timeout: 15 * time.Millisecond},
+				{Command: PolicyRule{Command: "aws", Args: []string{"s3", "..."}}, Timeout: time.Hour},
+			}},
+			[]string{"aws", "s3", "sync"},
+			15 * time.Millisecond,
+		},
+		{
+			"no timeout",
+			TimeoutPolicy{Default: 10 * time.Millisecond, Rules: []TimeoutRule{
+				{Command: PolicyRule{Command: "aws", Args: []string{"s3", "sync", "..."}}, Timeout: 0},
+			}},
+			[]string{"aws", "s3", "sync"},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := Wrap(
+				NewFuncExec(WithFuncMap(map[string]CmdFunc{
+					"aws": func(fc *FuncCmd) int {
+						time.Sleep(50 * time.Millisecond)
+						return 0
+					},
+				})),
+				WithTimeouts(tt.policy),
+			)
+
+			err := exec.Command(tt.args[0], tt.args[1:]...).Run()
+			if tt.wantTimeout == 0 {
+				if err != nil {
+					t.Errorf("Run() error = %v, want no timeout", err)
+				}
+				return
+			}
+
+			var timeoutErr *TimeoutError
+			if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Run() error = %v, want a *TimeoutError", err)
+			}
+			if timeoutErr.Timeout != tt.wantTimeout || timeoutErr.Elapsed < tt.wantTimeout {
+				t.Errorf("TimeoutError timeout %v elapsed %v, want timeout %v", timeoutErr.Timeout, timeoutErr.Elapsed, tt.wantTimeout)
+			}
+			if timeoutErr.Command != "aws s3 sync" {
+				t.Errorf("TimeoutError.Command = %q, want %q", timeoutErr.Command, "aws s3 sync")
+			}
+		})
+	}
+}
+
+func TestWithTimeouts_parentContext(t *testing.T) {
+	exec := Wrap(
+		NewFuncExec(WithFuncMap(map[string]CmdFunc{
+			"aws": func(fc *FuncCmd) int {
+				time.Sleep(50 * time.Millisecond)
+				return 0
+			},
+		})),
+		WithTimeouts(TimeoutPolicy{Default: time.Hour}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "aws")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	cancel()
+
+	err := cmd.Wait()
+	var timeoutErr *TimeoutError
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeoutErr) {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWithTimeouts_osExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		wantCode int
+	}{
+		{"exits on SIGTERM", `trap "exit 3" TERM; sleep 5 & wait`, 3},
+		{"killed after grace", `trap "" TERM; exec sleep 5`, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oExec := Wrap(NewOsExec(), WithTimeouts(TimeoutPolicy{
+				Default: 50 * time.Millisecond,
+				Grace:   100 * time.Millisecond,
+			}))
+
+			start := time.Now()
+			err := oExec.Command("sh", "-c", tt.script).Run()
+
+			var timeoutErr *TimeoutError
+			if !errors.As(err, &timeoutErr) {
+				t.Fatalf("Run() error = %v, want a *TimeoutError", err)
+			}
+			if ExitCode(err) != tt.wantCode {
+				t.Errorf("ExitCode() = %d, want %d", ExitCode(err), tt.wantCode)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Run() took %v, the command was not stopped", elapsed)
+			}
+		})
+	}
+}
+
+func TestWithTimeouts_contextReleased(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      bool
+		wait       bool
+		wantCancel bool
+	}{
+		{"never started", false, false, false},
+		{"running", true, false, true},
+		{"waited", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			exec := Wrap(NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+				<-release
+				return 0
+			})), WithTimeouts(TimeoutPolicy{}))
+
+			parent, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cmd := exec.CommandContext(parent, "aws").(*timeoutCmd)
+
+			if tt.start {
+				if err := cmd.Start(); err != nil {
+					t.Fatalf("Start() error = %v", err)
+				}
+			}
+			if tt.wait {
+				close(release)
+				if err := cmd.Wait(); err != nil {
+					t.Fatalf("Wait() error = %v", err)
+				}
+			}
+
+			// the command only follows its parent context while it runs
+			cancel()
+			if tt.wantCancel {
+				waitFor(t, func() bool { return cmd.ctx.Err() != nil })
+				if err := cmd.Wait(); !errors.Is(err, context.Canceled) {
+					t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+				}
+				close(release)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			if err := cmd.ctx.Err(); err != nil {
+				t.Errorf("context error = %v, want the context not to follow its parent", err)
+			}
+			if !tt.wait {
+				close(release)
+			}
+		})
+	}
+}
+
+func TestWithTimeouts_stop(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	graceful := NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+		<-fc.Signals()
+		fc.Stdout().Write([]byte("bye\n"))
+		return 3
+	}))
+	policy := TimeoutPolicy{Default: 100 * time.Millisecond, Grace: time.Second}
+	retry := RetryPolicy{MaxAttempts: 3, ExitCodes: []int{3}}
+
+	tests := []struct {
+		name string
+		exec Exec
+		cmd  []string
+	}{
+		{"func cmd", Wrap(graceful, WithTimeouts(policy)), []string{"server"}},
+		{"retried func cmd", Wrap(graceful, WithTimeouts(policy), WithRetry(retry)), []string{"server"}},
+		{
+			"retried os cmd",
+			Wrap(NewOsExec(), WithTimeouts(policy), WithRetry(retry)),
+			[]string{"sh", "-c", `trap "echo bye; exit 3" TERM; while :; do sleep 0.01; done`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			out, err := tt.exec.Command(tt.cmd[0], tt.cmd[1:]...).Output()
+
+			// the command is sent its stop signal and given the grace period rather than killed,
+			// and it's not retried once it has been stopped
+			var timeoutErr *TimeoutError
+			if !errors.As(err, &timeoutErr) || ExitCode(err) != 3 {
+				t.Errorf("Output() error = %v, want a *TimeoutError with exit code 3", err)
+			}
+			if string(out) != "bye\n" {
+				t.Errorf("Output() = %q, want %q", out, "bye\n")
+			}
+			if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+				t.Errorf("Output() took %v, want the command to exit when it was signaled", elapsed)
+			}
+		})
+	}
+}
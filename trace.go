@@ -0,0 +1,277 @@
+package puffin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentEnv is the env var used to pass trace context to child processes.
+// Its value uses the W3C trace context traceparent format
+const TraceparentEnv = "TRACEPARENT"
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the span context has a non-zero trace id and span id
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the span context formatted as a W3C traceparent header,
+// e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header into a SpanContext
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, errors.New("puffin: invalid traceparent " + traceparent)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, errors.New("puffin: invalid traceparent " + traceparent)
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, errors.New("puffin: invalid traceparent " + traceparent)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("puffin: invalid traceparent " + traceparent)
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, nil
+}
+
+// decodeHex decodes s into dst, it reports false if s is not exactly len(dst) bytes of lower case hex
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// spanContextKey is the context key for the current SpanContext
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx that carries sc as the current span
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the current span in ctx, if there is one
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Span is a single traced operation
+type Span interface {
+	// SpanContext returns the identity of the span
+	SpanContext() SpanContext
+
+	// SetAttributes adds attributes to the span as alternating keys and values
+	SetAttributes(kvs ...any)
+
+	// End completes the span, err is the error the operation failed with, if any
+	End(err error)
+}
+
+// Tracer creates spans. It is intentionally small so it can be adapted to any tracing library
+type Tracer interface {
+	// Start starts a new span that is a child of the current span in ctx, if there is one.
+	// The returned context carries the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// WithTracing returns a Middleware that creates a span for every command it runs.
+// The span is a child of the span in the context passed to CommandContext and has
+// the following attributes
+//
+//	process.command            the path of the command
+//	process.command_args       the command line arguments, redacted with the DefaultRedactor
+//	process.working_directory  the working directory of the command
+//	process.exit_code          the exit code of the command
+//	process.duration           how long the command ran for
+//
+// The span context is passed to the command in the TRACEPARENT env var so traced child
+// processes can join the trace. Fakes can read it with FuncCmd.TraceContext. The env of
+// the Cmd is only changed while it runs, so a Clone made afterwards doesn't join the old span
+func WithTracing(tracer Tracer) Middleware {
+	redactor := DefaultRedactor()
+
+	return WithHooks(func(ctx context.Context, cmd Cmd) *Hooks {
+		var span Span
+		var env []string
+		restore := false
+
+		return &Hooks{
+			BeforeStart: func() error {
+				_, span = tracer.Start(ctx, "exec "+commandName(cmd))
+				span.SetAttributes(
+					"process.command", cmd.Path(),
+					"process.command_args", redactor.Args(cmd.Args()),
+					"process.working_directory", cmd.Dir(),
+				)
+
+				if sc := span.SpanContext(); sc.IsValid() {
+					env, restore = cmd.Env(), true
+					cmd.SetEnv(setEnvVar(cmd.Environ(), TraceparentEnv, sc.Traceparent()))
+				}
+				return nil
+			},
+			Done: func(res Result) {
+				// the env is restored once the command is done rather than after Start, FuncCmds
+				// and middleware like WithRetry read it while the command runs
+				if restore {
+					cmd.SetEnv(env)
+				}
+				if span == nil {
+					return
+				}
+
+				span.SetAttributes(
+					"process.exit_code", res.ExitCode,
+					"process.duration", res.Duration,
+				)
+				span.End(res.Err)
+			},
+		}
+	})
+}
+
+// setEnvVar returns env with name set to value, replacing any existing values for name
+func setEnvVar(env []string, name, value string) []string {
+	updated := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k != name {
+			updated = append(updated, kv)
+		}
+	}
+
+	return append(updated, name+"="+value)
+}
+
+// SpanData is a span that has been recorded by an InMemoryTracer
+type SpanData struct {
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanContext
+	Attributes  map[string]any
+	StartTime   time.Time
+	Duration    time.Duration
+	Err         error
+}
+
+// InMemoryTracer is a Tracer that records spans in memory so they can be checked in tests
+type InMemoryTracer struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryTracer creates a new InMemoryTracer
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+// Start starts a new span that is a child of the current span in ctx
+func (t *InMemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &memorySpan{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Attributes: map[string]any{},
+			StartTime:  time.Now(),
+		},
+	}
+
+	parent, ok := SpanContextFromContext(ctx)
+	if ok {
+		span.data.Parent = parent
+		span.data.SpanContext.TraceID = parent.TraceID
+	} else {
+		rand.Read(span.data.SpanContext.TraceID[:])
+	}
+	rand.Read(span.data.SpanContext.SpanID[:])
+	span.data.SpanContext.Sampled = true
+
+	return ContextWithSpanContext(ctx, span.data.SpanContext), span
+}
+
+// Spans returns the spans that have ended, in the order they ended
+func (t *InMemoryTracer) Spans() []SpanData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]SpanData(nil), t.spans...)
+}
+
+// Reset removes all the recorded spans
+func (t *InMemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = nil
+}
+
+// memorySpan is a Span created by an InMemoryTracer
+type memorySpan struct {
+	tracer *InMemoryTracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the identity of the span
+func (s *memorySpan) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+// SetAttributes adds attributes to the span as alternating keys and values
+func (s *memorySpan) SetAttributes(kvs ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if key, ok := kvs[i].(string); ok {
+			s.data.Attributes[key] = kvs[i+1]
+		}
+	}
+}
+
+// End completes the span and records it with the tracer. Only the first call has any effect
+func (s *memorySpan) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.Duration = time.Since(s.data.StartTime)
+	s.data.Err = err
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.tracer.spans = append(s.tracer.spans, data)
+}
@@ -0,0 +1,153 @@
+package puffin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantSampled bool
+		wantErr     bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, true},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, true},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false, true},
+		{"empty", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.traceparent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("ParseTraceparent() sampled = %v, want %v", sc.Sampled, tt.wantSampled)
+			}
+			if tt.traceparent[:2] == "00" && sc.Traceparent() != tt.traceparent {
+				t.Errorf("SpanContext.Traceparent() = %v, want %v", sc.Traceparent(), tt.traceparent)
+			}
+		})
+	}
+}
+
+func TestWithTracing(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	exec := Wrap(
+		NewFuncExec(
+			WithEnv(map[string]string{"HOME": "/home/puffin"}),
+			WithFuncMap(map[string]CmdFunc{
+				"deploy": func(fc *FuncCmd) int {
+					sc, ok := fc.TraceContext()
+					if !ok {
+						return 1
+					}
+					fmt.Fprint(fc.Stdout(), sc.Traceparent())
+					return 0
+				},
+				"fail": Respond("", "boom", 3),
+			}),
+		),
+		WithTracing(tracer),
+	)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+
+	cmd := exec.CommandContext(ctx, "deploy", "--token", "secret")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	if !contains(cmd.Environ(), "HOME=/home/puffin") {
+		t.Errorf("Environ() = %v, the existing env was not kept", cmd.Environ())
+	}
+	// the command inherited the env of the Exec, and still does once it's done
+	if env := cmd.Env(); env != nil {
+		t.Errorf("Env() = %v, want nil once the command is done", env)
+	}
+
+	exec.Command("fail").Run()
+	parent.End(nil)
+
+	spans := tracer.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Spans() len = %d, want 3", len(spans))
+	}
+
+	deploy := spans[0]
+	if deploy.Name != "exec deploy" {
+		t.Errorf("span name = %q, want %q", deploy.Name, "exec deploy")
+	}
+	if deploy.Parent != parent.SpanContext() {
+		t.Errorf("span parent = %v, want %v", deploy.Parent, parent.SpanContext())
+	}
+	if string(out) != deploy.SpanContext.Traceparent() {
+		t.Errorf("command trace context = %s, want %s", out, deploy.SpanContext.Traceparent())
+	}
+	if got := fmt.Sprint(deploy.Attributes["process.command_args"]); got != "[deploy --token [REDACTED]]" {
+		t.Errorf("process.command_args = %v", got)
+	}
+	if deploy.Attributes["process.exit_code"] != 0 {
+		t.Errorf("process.exit_code = %v, want 0", deploy.Attributes["process.exit_code"])
+	}
+
+	fail := spans[1]
+	if fail.Parent.IsValid() {
+		t.Errorf("span parent = %v, want no parent", fail.Parent)
+	}
+	if fail.Attributes["process.exit_code"] != 3 || fail.Err == nil {
+		t.Errorf("process.exit_code = %v, err = %v, want 3 and an error", fail.Attributes["process.exit_code"], fail.Err)
+	}
+}
+
+func TestWithTracing_retry(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	attempts := 0
+	exec := Wrap(
+		NewFuncExec(WithDefaultFunc(func(fc *FuncCmd) int {
+			if attempts++; attempts == 1 {
+				return 1
+			}
+			sc, _ := fc.TraceContext()
+			fmt.Fprint(fc.Stdout(), sc.Traceparent())
+			return 0
+		})),
+		WithTracing(tracer),
+		WithRetry(RetryPolicy{InitialBackoff: time.Millisecond}),
+	)
+
+	cmd := exec.Command("deploy")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+
+	// every attempt runs with the env of the span
+	spans := tracer.Spans()
+	if len(spans) != 1 || string(out) != spans[0].SpanContext.Traceparent() {
+		t.Errorf("command trace context = %s, want the span of the command %v", out, spans)
+	}
+	if env := cmd.Env(); env != nil {
+		t.Errorf("Env() = %v, want nil once the command is done", env)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}